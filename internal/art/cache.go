@@ -0,0 +1,130 @@
+package art
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/lordxarus/ytmusic_cli/yt"
+)
+
+// Cache fetches thumbnails and keeps them on disk so each one is only
+// downloaded once
+type Cache struct {
+	dir    string
+	client *http.Client
+
+	mu     sync.Mutex
+	images map[string]image.Image
+}
+
+func NewCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("NewCache(): %w", err)
+	}
+	return &Cache{
+		dir:    dir,
+		client: http.DefaultClient,
+		images: make(map[string]image.Image),
+	}, nil
+}
+
+// Get returns the decoded image at url, downloading it if it isn't cached
+func (c *Cache) Get(url string) (image.Image, error) {
+	c.mu.Lock()
+	img, ok := c.images[url]
+	c.mu.Unlock()
+	if ok {
+		return img, nil
+	}
+
+	sum := sha1.Sum([]byte(url))
+	path := filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+
+	if _, err := os.Stat(path); err != nil {
+		if err = c.download(url, path); err != nil {
+			return nil, fmt.Errorf("Cache.Get(): %w", err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Cache.Get(): %w", err)
+	}
+	defer file.Close()
+
+	img, _, err = image.Decode(file)
+	if err != nil {
+		// Probably a truncated or non-image response, try again next time
+		os.Remove(path)
+		return nil, fmt.Errorf("Cache.Get(): failed to decode %s: %w", url, err)
+	}
+
+	c.mu.Lock()
+	c.images[url] = img
+	c.mu.Unlock()
+	return img, nil
+}
+
+func (c *Cache) download(url string, path string) error {
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download of %s failed: %s", url, resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(c.dir, "download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// googleusercontent thumbnails carry their size in the URL, e.g. =w60-h60-l90-rj
+var sizeParamRe = regexp.MustCompile(`=w\d+-h\d+`)
+
+// Pick returns the URL of the smallest thumbnail at least minWidth wide.
+// If they're all too small it asks for a bigger one where the URL allows it.
+func Pick(thumbnails []yt.Thumbnail, minWidth int) string {
+	if len(thumbnails) == 0 {
+		return ""
+	}
+
+	sorted := make([]yt.Thumbnail, len(thumbnails))
+	copy(sorted, thumbnails)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Width < sorted[j].Width })
+
+	for _, t := range sorted {
+		if t.Width >= minWidth {
+			return t.URL
+		}
+	}
+
+	largest := sorted[len(sorted)-1].URL
+	if sizeParamRe.MatchString(largest) {
+		return sizeParamRe.ReplaceAllString(largest, fmt.Sprintf("=w%d-h%d", minWidth, minWidth))
+	}
+	return largest
+}
@@ -0,0 +1,51 @@
+package art
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+)
+
+// The kitty graphics protocol caps each escape's payload at 4096 bytes
+const kittyChunk = 4096
+
+// WriteKitty transmits img as a PNG and displays it at the cursor, scaled by
+// the terminal to cols x rows cells. id lets a later DeleteKitty remove it.
+// See https://sw.kovidgoyal.net/kitty/graphics-protocol/
+func WriteKitty(w io.Writer, img image.Image, id uint32, cols, rows int) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return fmt.Errorf("WriteKitty(): %w", err)
+	}
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	var out bytes.Buffer
+	for first := true; first || len(payload) > 0; first = false {
+		chunk := payload[:min(kittyChunk, len(payload))]
+		payload = payload[len(chunk):]
+
+		more := 0
+		if len(payload) > 0 {
+			more = 1
+		}
+		// q=2 stops the terminal answering, tcell would read it as keys
+		if first {
+			fmt.Fprintf(&out, "\x1b_Ga=T,f=100,q=2,C=1,i=%d,c=%d,r=%d,m=%d;%s\x1b\\", id, cols, rows, more, chunk)
+		} else {
+			fmt.Fprintf(&out, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	if _, err := w.Write(out.Bytes()); err != nil {
+		return fmt.Errorf("WriteKitty(): %w", err)
+	}
+	return nil
+}
+
+// DeleteKitty removes every placement of image id and frees its data
+func DeleteKitty(w io.Writer, id uint32) error {
+	_, err := fmt.Fprintf(w, "\x1b_Ga=d,d=I,q=2,i=%d\x1b\\", id)
+	return err
+}
@@ -0,0 +1,90 @@
+package art
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Protocol is how an image gets onto the terminal
+type Protocol int
+
+const (
+	Auto Protocol = iota
+	Kitty
+	Sixel
+	HalfBlock
+	None
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case Auto:
+		return "auto"
+	case Kitty:
+		return "kitty"
+	case Sixel:
+		return "sixel"
+	case HalfBlock:
+		return "halfblock"
+	case None:
+		return "none"
+	}
+	return ""
+}
+
+func ParseProtocol(s string) (Protocol, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return Auto, nil
+	case "kitty":
+		return Kitty, nil
+	case "sixel":
+		return Sixel, nil
+	case "halfblock", "unicode":
+		return HalfBlock, nil
+	case "none", "off":
+		return None, nil
+	}
+	return Auto, fmt.Errorf("ParseProtocol(): unknown protocol %q, expected auto, kitty, sixel, halfblock or none", s)
+}
+
+// Terminals known to speak sixel. There's no reliable way to ask without
+// a round trip through the tty, which tcell owns.
+var sixelTerms = []string{"foot", "mlterm", "yaft", "contour", "sixel"}
+
+// Detect guesses the best protocol from the environment
+func Detect() Protocol {
+	term := os.Getenv("TERM")
+	termProgram := os.Getenv("TERM_PROGRAM")
+
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "", term == "xterm-kitty":
+		return Kitty
+	case termProgram == "WezTerm", termProgram == "ghostty", term == "xterm-ghostty":
+		return Kitty
+	}
+
+	// tmux and screen swallow graphics escapes
+	if os.Getenv("TMUX") != "" || strings.HasPrefix(term, "screen") {
+		return HalfBlock
+	}
+
+	for _, t := range sixelTerms {
+		if strings.Contains(term, t) {
+			return Sixel
+		}
+	}
+	if termProgram == "iTerm.app" || os.Getenv("WT_SESSION") != "" {
+		return Sixel
+	}
+	return HalfBlock
+}
+
+// Resolve turns Auto into whatever Detect finds
+func (p Protocol) Resolve() Protocol {
+	if p == Auto {
+		return Detect()
+	}
+	return p
+}
@@ -0,0 +1,119 @@
+package art
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Fit scales img to the largest size that fits in width x height pixels
+// without changing its aspect ratio
+func Fit(img image.Image, width, height int) *image.RGBA {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 || width <= 0 || height <= 0 {
+		return image.NewRGBA(image.Rect(0, 0, 0, 0))
+	}
+	w, h := width, b.Dy()*width/b.Dx()
+	if h > height {
+		w, h = b.Dx()*height/b.Dy(), height
+	}
+	return Scale(img, max(w, 1), max(h, 1))
+}
+
+// Scale resizes img to exactly width x height, averaging the source pixels
+// that land in each destination pixel. Album art is small enough that this
+// doesn't need to be clever.
+func Scale(img image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	b := img.Bounds()
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := max(b.Min.X+(x+1)*b.Dx()/width, x0+1)
+
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+pr, g+pg, bl+pb, a+pa
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// DrawHalfBlock draws img into the given cells using ▀, the top pixel as the
+// foreground and the bottom one as the background. Works on any terminal
+// with true color, tcell downgrades it for the rest.
+func DrawHalfBlock(screen tcell.Screen, img image.Image, x, y, width, height int) {
+	scaled := Fit(img, width, height*2)
+	b := scaled.Bounds()
+	// Center it in the area
+	offX := x + (width-b.Dx())/2
+	offY := y + (height-(b.Dy()+1)/2)/2
+
+	for row := 0; row < (b.Dy()+1)/2; row++ {
+		for col := 0; col < b.Dx(); col++ {
+			top := scaled.RGBAAt(col, row*2)
+			style := tcell.StyleDefault.Foreground(tcellColor(top))
+			if row*2+1 < b.Dy() {
+				bottom := scaled.RGBAAt(col, row*2+1)
+				style = style.Background(tcellColor(bottom))
+			}
+			screen.SetContent(offX+col, offY+row, '▀', nil, style)
+		}
+	}
+}
+
+func tcellColor(c color.RGBA) tcell.Color {
+	return tcell.NewRGBColor(int32(c.R), int32(c.G), int32(c.B))
+}
+
+// Used when the terminal doesn't report its size in pixels
+const (
+	defaultCellWidth  = 10
+	defaultCellHeight = 20
+)
+
+// CellSize returns the size of one cell in pixels
+func CellSize(tty tcell.Tty) (width, height int) {
+	if tty != nil {
+		if ws, err := tty.WindowSize(); err == nil {
+			if width, height = ws.CellDimensions(); width > 0 && height > 0 {
+				return width, height
+			}
+		}
+	}
+	return defaultCellWidth, defaultCellHeight
+}
+
+// Layout works out where an image of the given size goes in an area of cols x
+// rows cells, keeping its aspect ratio. It returns the cells the image covers,
+// relative to the area, and the pixel size to scale it to.
+func Layout(size image.Point, cols, rows, cellWidth, cellHeight int) (cells image.Rectangle, pixels image.Point) {
+	if size.X == 0 || size.Y == 0 || cols <= 0 || rows <= 0 {
+		return image.Rectangle{}, image.Point{}
+	}
+	maxW, maxH := cols*cellWidth, rows*cellHeight
+	w, h := maxW, size.Y*maxW/size.X
+	if h > maxH {
+		w, h = size.X*maxH/size.Y, maxH
+	}
+	pixels = image.Pt(max(w, 1), max(h, 1))
+
+	usedCols := min((pixels.X+cellWidth-1)/cellWidth, cols)
+	usedRows := min((pixels.Y+cellHeight-1)/cellHeight, rows)
+	offX, offY := (cols-usedCols)/2, (rows-usedRows)/2
+	return image.Rect(offX, offY, offX+usedCols, offY+usedRows), pixels
+}
@@ -0,0 +1,94 @@
+package art
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// A 6x6x6 color cube. Dithering makes up for most of what's lost and it keeps
+// the encoder simple.
+var sixelPalette = func() color.Palette {
+	p := make(color.Palette, 0, 216)
+	for r := 0; r < 6; r++ {
+		for g := 0; g < 6; g++ {
+			for b := 0; b < 6; b++ {
+				p = append(p, color.RGBA{uint8(r * 51), uint8(g * 51), uint8(b * 51), 0xff})
+			}
+		}
+	}
+	return p
+}()
+
+// WriteSixel draws img at the cursor at its actual pixel size, sixel has no
+// way to scale. Fit it to the cell area first.
+func WriteSixel(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), sixelPalette)
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), img, b.Min)
+	width, height := paletted.Bounds().Dx(), paletted.Bounds().Dy()
+
+	var out bytes.Buffer
+	// P2=1 leaves pixels we don't set alone instead of painting them black
+	fmt.Fprintf(&out, "\x1bP0;1;0q\"1;1;%d;%d", width, height)
+	for i, c := range sixelPalette {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+
+	row := make([]byte, width)
+	for band := 0; band < height; band += 6 {
+		used := make(map[uint8]bool)
+		for y := band; y < min(band+6, height); y++ {
+			for x := 0; x < width; x++ {
+				used[paletted.ColorIndexAt(x, y)] = true
+			}
+		}
+
+		for i := range sixelPalette {
+			idx := uint8(i)
+			if !used[idx] {
+				continue
+			}
+			for x := 0; x < width; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && band+dy < height; dy++ {
+					if paletted.ColorIndexAt(x, band+dy) == idx {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = '?' + bits
+			}
+			fmt.Fprintf(&out, "#%d", idx)
+			writeSixelRLE(&out, row)
+			// Back to the start of the band for the next color
+			out.WriteByte('$')
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\")
+
+	if _, err := w.Write(out.Bytes()); err != nil {
+		return fmt.Errorf("WriteSixel(): %w", err)
+	}
+	return nil
+}
+
+// writeSixelRLE writes row using !<count><char> for runs
+func writeSixelRLE(out *bytes.Buffer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(out, "!%d%c", n, row[i])
+		} else {
+			out.Write(row[i:j])
+		}
+		i = j
+	}
+}
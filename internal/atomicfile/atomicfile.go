@@ -0,0 +1,27 @@
+// Package atomicfile writes files by way of a temp file beside them, so a
+// crash part way through can't leave a half written file behind.
+package atomicfile
+
+import (
+	"io/fs"
+	"os"
+)
+
+// WriteFile writes data to path with perm, replacing what was there only
+// once all of it is written
+func WriteFile(path string, data []byte, perm fs.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of a temp file left behind before
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// What reisen hands over for one frame: 1024 interleaved stereo float64s,
+// about the size of an AAC or Opus frame
+const (
+	frameSamples = 1024
+	// The speaker asks for a tenth of a second at a time
+	readSize   = 4410
+	bufferSize = 32 * 2 * 8 * 1024
+)
+
+func frameData() []byte {
+	data := make([]byte, frameSamples*2*8)
+	for i := 0; i < frameSamples*2; i++ {
+		binary.NativeEndian.PutUint64(data[i*8:], math.Float64bits(math.Sin(float64(i))))
+	}
+	return data
+}
+
+// decodeBinaryRead is how frames used to be turned into samples
+func decodeBinaryRead(data []byte, send func([2]float64)) {
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		var sample [2]float64
+		binary.Read(reader, binary.LittleEndian, &sample[0])
+		binary.Read(reader, binary.LittleEndian, &sample[1])
+		send(sample)
+	}
+}
+
+func BenchmarkDecodeBinaryRead(b *testing.B) {
+	data := frameData()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		decodeBinaryRead(data, func([2]float64) {})
+	}
+}
+
+func BenchmarkDecodeAppendStereo(b *testing.B) {
+	data := frameData()
+	b.SetBytes(int64(len(data)))
+	var block [][2]float64
+	for i := 0; i < b.N; i++ {
+		block = AppendStereo(block[:0], data)
+	}
+}
+
+// BenchmarkPipelineChannel is the old decoder to speaker path, one channel
+// send and receive per sample
+func BenchmarkPipelineChannel(b *testing.B) {
+	data := frameData()
+	b.SetBytes(int64(len(data)))
+	samples := make(chan [2]float64, bufferSize)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			decodeBinaryRead(data, func(sample [2]float64) { samples <- sample })
+		}
+		close(samples)
+	}()
+
+	buf := make([][2]float64, readSize)
+	for {
+		n := 0
+		for n < len(buf) {
+			sample, ok := <-samples
+			if !ok {
+				break
+			}
+			buf[n] = sample
+			n++
+		}
+		if n < len(buf) {
+			break
+		}
+	}
+}
+
+// BenchmarkPipelineRing is the same path a frame at a time through a Ring
+func BenchmarkPipelineRing(b *testing.B) {
+	data := frameData()
+	b.SetBytes(int64(len(data)))
+	ring := NewRing(bufferSize)
+	go func() {
+		var block [][2]float64
+		for i := 0; i < b.N; i++ {
+			block = AppendStereo(block[:0], data)
+			ring.Write(block)
+		}
+		ring.Close()
+	}()
+
+	buf := make([][2]float64, readSize)
+	for {
+		if n, _ := ring.Read(buf); n < len(buf) {
+			break
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package audio
+
+import "math"
+
+// Biquad is a second order IIR filter over stereo samples, each side with
+// its own state. It's in transposed direct form II.
+type Biquad struct {
+	b0, b1, b2 float64
+	a1, a2     float64
+	z1, z2     [2]float64
+}
+
+// NewBiquad makes a filter from its numerator b and denominator a, which
+// needn't be normalised
+func NewBiquad(b, a [3]float64) Biquad {
+	var f Biquad
+	f.Set(b, a)
+	return f
+}
+
+// Set changes the coefficients without clearing the state, so a filter can
+// be retuned while audio goes through it
+func (f *Biquad) Set(b, a [3]float64) {
+	f.b0, f.b1, f.b2 = b[0]/a[0], b[1]/a[0], b[2]/a[0]
+	f.a1, f.a2 = a[1]/a[0], a[2]/a[0]
+}
+
+// Process filters one sample
+func (f *Biquad) Process(x [2]float64) [2]float64 {
+	var y [2]float64
+	for c := range x {
+		y[c] = f.b0*x[c] + f.z1[c]
+		f.z1[c] = f.b1*x[c] - f.a1*y[c] + f.z2[c]
+		f.z2[c] = f.b2*x[c] - f.a2*y[c]
+	}
+	return y
+}
+
+// Reset clears the state, as if nothing had gone through yet
+func (f *Biquad) Reset() {
+	f.z1, f.z2 = [2]float64{}, [2]float64{}
+}
+
+// Peaking, LowShelf and HighShelf are the filters from RBJ's Audio EQ
+// Cookbook for audio at rate, centred on or turning over at freq, with the
+// given Q and gain in dB. They return b and a for NewBiquad() or Set().
+
+func Peaking(rate, freq, q, gain float64) (b, a [3]float64) {
+	amp, cos, alpha := cookbook(rate, freq, q, gain)
+	b = [3]float64{1 + alpha*amp, -2 * cos, 1 - alpha*amp}
+	a = [3]float64{1 + alpha/amp, -2 * cos, 1 - alpha/amp}
+	return b, a
+}
+
+func LowShelf(rate, freq, q, gain float64) (b, a [3]float64) {
+	amp, cos, alpha := cookbook(rate, freq, q, gain)
+	k := 2 * math.Sqrt(amp) * alpha
+	b = [3]float64{
+		amp * ((amp + 1) - (amp-1)*cos + k),
+		2 * amp * ((amp - 1) - (amp+1)*cos),
+		amp * ((amp + 1) - (amp-1)*cos - k),
+	}
+	a = [3]float64{
+		(amp + 1) + (amp-1)*cos + k,
+		-2 * ((amp - 1) + (amp+1)*cos),
+		(amp + 1) + (amp-1)*cos - k,
+	}
+	return b, a
+}
+
+func HighShelf(rate, freq, q, gain float64) (b, a [3]float64) {
+	amp, cos, alpha := cookbook(rate, freq, q, gain)
+	k := 2 * math.Sqrt(amp) * alpha
+	b = [3]float64{
+		amp * ((amp + 1) + (amp-1)*cos + k),
+		-2 * amp * ((amp - 1) + (amp+1)*cos),
+		amp * ((amp + 1) + (amp-1)*cos - k),
+	}
+	a = [3]float64{
+		(amp + 1) - (amp-1)*cos + k,
+		2 * ((amp - 1) - (amp+1)*cos),
+		(amp + 1) - (amp-1)*cos - k,
+	}
+	return b, a
+}
+
+// cookbook works out the cookbook's A, cos(w0) and alpha
+func cookbook(rate, freq, q, gain float64) (amp, cos, alpha float64) {
+	w0 := 2 * math.Pi * freq / rate
+	return math.Pow(10, gain/40), math.Cos(w0), math.Sin(w0) / (2 * q)
+}
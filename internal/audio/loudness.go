@@ -0,0 +1,229 @@
+package audio
+
+import (
+	"math"
+)
+
+// Loudness and true peak as measured by Meter are floored here, it's what
+// EBU R128's absolute gate counts as silence
+const Silence = -70.0
+
+// Blocks are binned this finely, in LU, to keep a histogram instead of every
+// block
+const binsPerLU = 10
+
+// Meter measures integrated loudness and true peak of stereo audio at one
+// sample rate, as ITU-R BS.1770 and EBU R128 describe them. It keeps a
+// histogram of gating block loudness rather than the blocks themselves so it
+// doesn't grow with the track.
+type Meter struct {
+	// K-weighting
+	shelf, highpass Biquad
+
+	// Samples in each 100ms step, a gating block is 4 steps overlapping
+	// the last by 3
+	stepSize int
+	stepSum  float64
+	stepLen  int
+	steps    [4]float64
+	stepped  int
+	blocks   Blocks
+
+	upsampler upsampler
+	peak      float64
+}
+
+// Blocks counts gating blocks by loudness, keyed by the floor of LUFS times
+// binsPerLU. Only blocks above the absolute gate are counted.
+type Blocks map[int]int
+
+func NewMeter(rate int) *Meter {
+	m := &Meter{
+		stepSize:  rate / 10,
+		blocks:    make(Blocks),
+		upsampler: newUpsampler(rate),
+	}
+	m.shelf, m.highpass = kWeighting(float64(rate))
+	return m
+}
+
+// kWeighting is the pre-filter and RLB filter from BS.1770, worked out for
+// rate the way libebur128 does rather than using the 48kHz coefficients
+func kWeighting(rate float64) (shelf, highpass Biquad) {
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	shelf = NewBiquad(
+		[3]float64{vh + vb*k/q + k*k, 2 * (k*k - vh), vh - vb*k/q + k*k},
+		[3]float64{1 + k/q + k*k, 2 * (k*k - 1), 1 - k/q + k*k},
+	)
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	highpass = NewBiquad(
+		[3]float64{1, -2, 1},
+		[3]float64{1, 2 * (k*k - 1) / (1 + k/q + k*k), (1 - k/q + k*k) / (1 + k/q + k*k)},
+	)
+	return shelf, highpass
+}
+
+// Add measures samples, which follow on from the last lot
+func (m *Meter) Add(samples [][2]float64) {
+	for _, s := range samples {
+		m.peak = max(m.peak, m.upsampler.peak(s))
+
+		w := m.highpass.Process(m.shelf.Process(s))
+		m.stepSum += w[0]*w[0] + w[1]*w[1]
+		m.stepLen++
+		if m.stepLen == m.stepSize {
+			m.endStep()
+		}
+	}
+}
+
+func (m *Meter) endStep() {
+	m.steps[m.stepped%len(m.steps)] = m.stepSum
+	m.stepped++
+	m.stepSum, m.stepLen = 0, 0
+	if m.stepped < len(m.steps) {
+		return
+	}
+
+	var sum float64
+	for _, step := range m.steps {
+		sum += step
+	}
+	if l := loudness(sum / float64(m.stepSize*len(m.steps))); l >= Silence {
+		m.blocks[int(math.Floor(l*binsPerLU))]++
+	}
+}
+
+// Integrated is the gated loudness in LUFS, false if it was all silence
+func (m *Meter) Integrated() (float64, bool) {
+	return Integrated(m.blocks)
+}
+
+// TruePeak is the highest the signal gets between samples as well as at
+// them, in dBTP
+func (m *Meter) TruePeak() float64 {
+	if m.peak <= 0 {
+		return Silence
+	}
+	return max(20*math.Log10(m.peak), Silence)
+}
+
+// Blocks returns a copy of the histogram, e.g. to keep for working out an
+// album's loudness later
+func (m *Meter) Blocks() Blocks {
+	blocks := make(Blocks, len(m.blocks))
+	for bin, count := range m.blocks {
+		blocks[bin] = count
+	}
+	return blocks
+}
+
+// Integrated is the gated loudness in LUFS of all the blocks together, e.g.
+// the tracks of an album. It's false if there aren't any.
+func Integrated(blocks ...Blocks) (float64, bool) {
+	gated := func(threshold float64) (float64, bool) {
+		var sum float64
+		var count int
+		for _, b := range blocks {
+			for bin, n := range b {
+				l := (float64(bin) + 0.5) / binsPerLU
+				if l < threshold {
+					continue
+				}
+				sum += float64(n) * energy(l)
+				count += n
+			}
+		}
+		if count == 0 {
+			return Silence, false
+		}
+		return loudness(sum / float64(count)), true
+	}
+
+	ungated, ok := gated(Silence)
+	if !ok {
+		return Silence, false
+	}
+	return gated(ungated - 10)
+}
+
+// loudness is the LUFS of a K-weighted mean square summed over channels
+func loudness(power float64) float64 {
+	if power <= 0 {
+		return math.Inf(-1)
+	}
+	return -0.691 + 10*math.Log10(power)
+}
+
+func energy(loudness float64) float64 {
+	return math.Pow(10, (loudness+0.691)/10)
+}
+
+// Oversampling by 4 is what BS.1770 asks for at 44.1 and 48kHz, above
+// 96kHz the samples are close enough together on their own
+const (
+	oversample = 4
+	peakTaps   = 12
+)
+
+// upsampler finds peaks between samples by interpolating with a windowed
+// sinc at oversample times the rate
+type upsampler struct {
+	factor  int
+	phases  [][]float64
+	history [2][peakTaps]float64
+}
+
+func newUpsampler(rate int) upsampler {
+	factor := oversample
+	if rate >= 96000 {
+		factor = 1
+	}
+
+	u := upsampler{factor: factor}
+	size := peakTaps * factor
+	for phase := 0; phase < factor; phase++ {
+		taps := make([]float64, peakTaps)
+		for i := range taps {
+			// Where tap i of this phase falls in the full filter
+			n := float64(i*factor+phase) - float64(size-1)/2
+			x := n / float64(factor)
+			sinc := 1.0
+			if x != 0 {
+				sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+			}
+			window := 0.5 + 0.5*math.Cos(2*math.Pi*n/float64(size))
+			taps[i] = sinc * window
+		}
+		u.phases = append(u.phases, taps)
+	}
+	return u
+}
+
+// peak takes the next sample and returns the highest absolute value of the
+// interpolated points leading up to it
+func (u *upsampler) peak(s [2]float64) float64 {
+	var highest float64
+	for c := range s {
+		h := &u.history[c]
+		copy(h[1:], h[:len(h)-1])
+		h[0] = s[c]
+		highest = max(highest, math.Abs(s[c]))
+		if u.factor == 1 {
+			continue
+		}
+		for _, taps := range u.phases {
+			var y float64
+			for i, t := range taps {
+				y += t * h[i]
+			}
+			highest = max(highest, math.Abs(y))
+		}
+	}
+	return highest
+}
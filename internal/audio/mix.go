@@ -0,0 +1,38 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// AppendStereo decodes data, interleaved stereo float64 samples as libav
+// writes them, appends it to dst and returns the extended slice. A trailing
+// partial frame is ignored.
+//
+// There's no mixing here: reisen has libswresample convert every stream to
+// reisen.StandardChannelCount (stereo) channels, which folds mono and
+// surround down with libav's own matrix.
+func AppendStereo(dst [][2]float64, data []byte) [][2]float64 {
+	const frameSize = 2 * 8
+	for i := 0; i+frameSize <= len(data); i += frameSize {
+		// libav writes samples in native byte order
+		dst = append(dst, [2]float64{
+			math.Float64frombits(binary.NativeEndian.Uint64(data[i:])),
+			math.Float64frombits(binary.NativeEndian.Uint64(data[i+8:])),
+		})
+	}
+	return dst
+}
+
+// Window returns the part of block that lies between samples start and
+// end of the track, block starting at sample at. end is 0 when the
+// track's length isn't known.
+func Window(block [][2]float64, at, start, end int) [][2]float64 {
+	if end > 0 {
+		block = block[:min(max(end-at, 0), len(block))]
+	}
+	if skip := start - at; skip > 0 {
+		block = block[min(skip, len(block)):]
+	}
+	return block
+}
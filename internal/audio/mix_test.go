@@ -0,0 +1,65 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestAppendStereo(t *testing.T) {
+	values := []float64{0.5, -0.5, 1, -1, 0.25, 0}
+	data := make([]byte, len(values)*8)
+	for i, v := range values {
+		binary.NativeEndian.PutUint64(data[i*8:], math.Float64bits(v))
+	}
+	// Half a frame on the end is ignored
+	data = append(data, make([]byte, 8)...)
+
+	dst := [][2]float64{{9, 9}}
+	got := AppendStereo(dst, data)
+	want := [][2]float64{{9, 9}, {0.5, -0.5}, {1, -1}, {0.25, 0}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("frame %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if got := AppendStereo(nil, data[:8]); len(got) != 0 {
+		t.Errorf("got %v from half a frame, want nothing", got)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	block := make([][2]float64, 10)
+	for i := range block {
+		block[i] = [2]float64{float64(i), 0}
+	}
+
+	tests := []struct {
+		name           string
+		at, start, end int
+		first, n       int
+	}{
+		{"all of it", 100, 0, 0, 0, 10},
+		{"seeked into the block", 100, 104, 0, 4, 6},
+		{"seeked past the block", 100, 200, 0, 0, 0},
+		// Encoder padding past the container's length is cut off
+		{"padding at the end", 100, 0, 107, 0, 7},
+		{"all padding", 100, 0, 100, 0, 0},
+		{"past the end", 200, 0, 150, 0, 0},
+		{"both ends", 100, 102, 105, 2, 3},
+	}
+	for _, test := range tests {
+		got := Window(block, test.at, test.start, test.end)
+		if len(got) != test.n {
+			t.Errorf("%s: got %d samples, want %d", test.name, len(got), test.n)
+			continue
+		}
+		if test.n > 0 && got[0][0] != float64(test.first) {
+			t.Errorf("%s: starts at sample %v, want %d", test.name, got[0][0], test.first)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package audio works on decoded stereo samples. It moves them from the
+// decoder to the speaker in blocks rather than one at a time, filters them
+// and measures how loud they are.
+package audio
+
+import "sync"
+
+// Ring is a fixed size buffer of stereo samples between one writer and one
+// reader. Write blocks while it's full and Read while it's empty,
+// ReadAvailable never does. Each copy holds the lock once for a whole
+// block.
+type Ring struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    [][2]float64
+	start  int
+	len    int
+	closed bool
+}
+
+func NewRing(size int) *Ring {
+	r := &Ring{buf: make([][2]float64, size)}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// Write copies all of samples in, waiting for room as needed. It returns
+// false if the ring was closed first.
+func (r *Ring) Write(samples [][2]float64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for len(samples) > 0 {
+		for r.len == len(r.buf) && !r.closed {
+			r.cond.Wait()
+		}
+		if r.closed {
+			return false
+		}
+
+		// The free space may wrap, copy up to the end of buf first
+		end := (r.start + r.len) % len(r.buf)
+		n := copy(r.buf[end:min(end+len(r.buf)-r.len, len(r.buf))], samples)
+		r.len += n
+		samples = samples[n:]
+		r.cond.Broadcast()
+	}
+	return true
+}
+
+// Read fills samples, waiting for the writer as needed. It only comes up
+// short once the ring is closed and empty, ok is false when nothing was
+// read.
+func (r *Ring) Read(samples [][2]float64) (n int, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n < len(samples) {
+		for r.len == 0 && !r.closed {
+			r.cond.Wait()
+		}
+		if r.len == 0 {
+			break
+		}
+		n += r.copyOut(samples[n:])
+	}
+	return n, n > 0
+}
+
+// ReadAvailable fills samples with what's there without waiting for the
+// writer. ok is false once the ring is closed and empty.
+func (r *Ring) ReadAvailable(samples [][2]float64) (n int, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// What's buffered may wrap, taking two copies
+	for n < len(samples) && r.len > 0 {
+		n += r.copyOut(samples[n:])
+	}
+	return n, n > 0 || !r.closed
+}
+
+// copyOut copies as much as it can up to the end of buf and wakes the
+// writer. Called with mu held.
+func (r *Ring) copyOut(samples [][2]float64) int {
+	copied := copy(samples, r.buf[r.start:min(r.start+r.len, len(r.buf))])
+	r.start = (r.start + copied) % len(r.buf)
+	r.len -= copied
+	r.cond.Broadcast()
+	return copied
+}
+
+// Len is how many samples are waiting to be read
+func (r *Ring) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.len
+}
+
+// Close stops any more being written. The reader still gets what's left,
+// a blocked writer gives up.
+func (r *Ring) Close() {
+	r.mu.Lock()
+	r.closed = true
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
@@ -0,0 +1,193 @@
+package audio
+
+import (
+	"testing"
+	"time"
+)
+
+func frames(from, n int) [][2]float64 {
+	out := make([][2]float64, n)
+	for i := range out {
+		out[i] = [2]float64{float64(from + i), -float64(from + i)}
+	}
+	return out
+}
+
+func checkFrames(t *testing.T, got [][2]float64, from int) {
+	t.Helper()
+	for i, frame := range got {
+		if want := [2]float64{float64(from + i), -float64(from + i)}; frame != want {
+			t.Fatalf("frame %d: got %v, want %v", i, frame, want)
+		}
+	}
+}
+
+func TestRingWraps(t *testing.T) {
+	r := NewRing(5)
+	buf := make([][2]float64, 3)
+	next := 0
+	// Writes and reads of 3 in a ring of 5 keep moving the start round,
+	// so most copies have to wrap
+	for round := 0; round < 10; round++ {
+		if !r.Write(frames(next, 3)) {
+			t.Fatal("Write() failed on an open ring")
+		}
+		if r.Len() != 3 {
+			t.Fatalf("round %d: Len() = %d, want 3", round, r.Len())
+		}
+		n, ok := r.Read(buf)
+		if n != 3 || !ok {
+			t.Fatalf("round %d: Read() = %d, %t", round, n, ok)
+		}
+		checkFrames(t, buf, next)
+		next += 3
+	}
+}
+
+func TestRingWriteBiggerThanRing(t *testing.T) {
+	r := NewRing(4)
+	done := make(chan bool, 1)
+	go func() {
+		done <- r.Write(frames(0, 10))
+		r.Close()
+	}()
+
+	var got [][2]float64
+	buf := make([][2]float64, 3)
+	for {
+		n, ok := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if !ok {
+			break
+		}
+	}
+	if !<-done {
+		t.Fatal("Write() failed")
+	}
+	if len(got) != 10 {
+		t.Fatalf("read %d frames, want 10", len(got))
+	}
+	checkFrames(t, got, 0)
+}
+
+func TestRingPartialReadAfterClose(t *testing.T) {
+	r := NewRing(8)
+	r.Write(frames(0, 5))
+	r.Close()
+
+	buf := make([][2]float64, 3)
+	if n, ok := r.Read(buf); n != 3 || !ok {
+		t.Fatalf("first Read() = %d, %t, want 3, true", n, ok)
+	}
+	checkFrames(t, buf, 0)
+	// Only 2 left, the read comes up short instead of waiting
+	if n, ok := r.Read(buf); n != 2 || !ok {
+		t.Fatalf("second Read() = %d, %t, want 2, true", n, ok)
+	}
+	checkFrames(t, buf[:2], 3)
+	if n, ok := r.Read(buf); n != 0 || ok {
+		t.Fatalf("Read() of a closed empty ring = %d, %t, want 0, false", n, ok)
+	}
+	if r.Write(frames(0, 1)) {
+		t.Fatal("Write() to a closed ring succeeded")
+	}
+}
+
+func TestRingCloseWakesReader(t *testing.T) {
+	r := NewRing(8)
+	type result struct {
+		n  int
+		ok bool
+	}
+	read := make(chan result)
+	go func() {
+		n, ok := r.Read(make([][2]float64, 4))
+		read <- result{n, ok}
+	}()
+
+	// The reader is waiting on an empty ring
+	select {
+	case res := <-read:
+		t.Fatalf("Read() of an empty ring returned %d, %t", res.n, res.ok)
+	case <-time.After(20 * time.Millisecond):
+	}
+	r.Close()
+	select {
+	case res := <-read:
+		if res.n != 0 || res.ok {
+			t.Fatalf("Read() = %d, %t, want 0, false", res.n, res.ok)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close() didn't wake the reader")
+	}
+}
+
+func TestRingCloseWakesWriter(t *testing.T) {
+	r := NewRing(2)
+	wrote := make(chan bool)
+	go func() {
+		wrote <- r.Write(frames(0, 5))
+	}()
+
+	select {
+	case <-wrote:
+		t.Fatal("Write() into a full ring returned")
+	case <-time.After(20 * time.Millisecond):
+	}
+	r.Close()
+	select {
+	case ok := <-wrote:
+		if ok {
+			t.Fatal("Write() = true after Close(), want false")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close() didn't wake the writer")
+	}
+	// What fitted before the close is still there to read
+	buf := make([][2]float64, 4)
+	n, _ := r.Read(buf)
+	if n != 2 {
+		t.Fatalf("read %d frames after Close(), want 2", n)
+	}
+	checkFrames(t, buf[:n], 0)
+}
+
+func TestRingReadAvailable(t *testing.T) {
+	r := NewRing(5)
+	// Move the start round so what's buffered wraps
+	r.Write(frames(0, 3))
+	r.Read(make([][2]float64, 3))
+	r.Write(frames(3, 4))
+
+	// Partly filled and still open, it takes what's there without waiting
+	buf := make([][2]float64, 8)
+	read := make(chan int)
+	go func() {
+		n, ok := r.ReadAvailable(buf)
+		if !ok {
+			n = -1
+		}
+		read <- n
+	}()
+	select {
+	case n := <-read:
+		if n != 4 {
+			t.Fatalf("ReadAvailable() = %d, want 4, true", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadAvailable() waited on a partly filled ring")
+	}
+	checkFrames(t, buf[:4], 3)
+
+	if n, ok := r.ReadAvailable(buf); n != 0 || !ok {
+		t.Fatalf("ReadAvailable() of an open empty ring = %d, %t, want 0, true", n, ok)
+	}
+	r.Write(frames(7, 1))
+	r.Close()
+	if n, ok := r.ReadAvailable(buf); n != 1 || !ok {
+		t.Fatalf("ReadAvailable() after Close() = %d, %t, want 1, true", n, ok)
+	}
+	if n, ok := r.ReadAvailable(buf); n != 0 || ok {
+		t.Fatalf("ReadAvailable() of a closed empty ring = %d, %t, want 0, false", n, ok)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/lordxarus/ytmusic_cli/yt"
+	"github.com/lordxarus/ytmusic_cli/yt/auth"
+)
+
+// newClient prefers credentials saved by the auth subcommand and falls
+// back to OAUTH_TOKEN from .env
+func (s *profileSession) newClient() (*yt.YTMClient, error) {
+	store, err := auth.Load(s.credentialsPath)
+	switch {
+	case err == nil:
+		return yt.NewWithCredentials(store, s.brandId, s.cachePath)
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("profileSession.newClient(): %w", err)
+	case oauthToken == "":
+		return nil, errors.New("profileSession.newClient(): not logged in, run `ytmusic_cli auth` or set OAUTH_TOKEN in .env")
+	}
+	return yt.New(oauthToken, s.brandId, s.cachePath)
+}
+
+func authCommand(args []string) error {
+	mode := "oauth"
+	if len(args) > 0 && (args[0] == "oauth" || args[0] == "browser" || args[0] == "status" || args[0] == "logout") {
+		mode = args[0]
+		args = args[1:]
+	}
+
+	switch mode {
+	case "browser":
+		return authBrowser(args)
+	case "status":
+		return authStatus()
+	case "logout":
+		if err := os.Remove(session().credentialsPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("auth logout: %w", err)
+		}
+		fmt.Println("removed", session().credentialsPath)
+		return nil
+	}
+	return authOAuth(args)
+}
+
+// authOAuth runs the OAuth device flow. ytmusicapi needs a client ID and
+// secret for a "TVs and Limited Input devices" OAuth client from the
+// Google Cloud console.
+func authOAuth(args []string) error {
+	flags := flag.NewFlagSet("auth", flag.ExitOnError)
+	clientId := flags.String("client-id", os.Getenv("YTM_CLIENT_ID"), "OAuth client ID (YTM_CLIENT_ID)")
+	clientSecret := flags.String("client-secret", os.Getenv("YTM_CLIENT_SECRET"), "OAuth client secret (YTM_CLIENT_SECRET)")
+	deviceURL := flags.String("device-url", auth.GoogleEndpoints.DeviceCodeURL, "device code endpoint")
+	tokenURL := flags.String("token-url", auth.GoogleEndpoints.TokenURL, "token endpoint")
+	flags.Parse(args)
+
+	if *clientId == "" || *clientSecret == "" {
+		return errors.New("auth: a client ID and secret are required, pass -client-id/-client-secret or set YTM_CLIENT_ID/YTM_CLIENT_SECRET")
+	}
+
+	creds := auth.Credentials{
+		Kind:         auth.KindOAuth,
+		ClientId:     *clientId,
+		ClientSecret: *clientSecret,
+	}
+	// Only remember the endpoints if they were overridden
+	if *deviceURL != auth.GoogleEndpoints.DeviceCodeURL || *tokenURL != auth.GoogleEndpoints.TokenURL {
+		creds.Endpoints = &auth.Endpoints{DeviceCodeURL: *deviceURL, TokenURL: *tokenURL}
+	}
+	oauth := creds.OAuthConfig()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	code, err := oauth.RequestDeviceCode(ctx)
+	if err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	fmt.Printf("Go to %s and enter the code %s\n", code.VerificationURL, code.UserCode)
+	fmt.Println("Waiting for you to authorize...")
+
+	token, err := oauth.PollToken(ctx, code)
+	if err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	creds.Token = &token
+
+	if _, err = auth.Save(session().credentialsPath, creds); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	fmt.Println("saved credentials to", session().credentialsPath)
+	return nil
+}
+
+// authBrowser imports request headers copied from a logged in
+// music.youtube.com tab
+func authBrowser(args []string) error {
+	flags := flag.NewFlagSet("auth browser", flag.ExitOnError)
+	file := flags.String("file", "", "read headers from this file instead of stdin")
+	flags.Parse(args)
+
+	var in io.Reader = os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("auth browser: %w", err)
+		}
+		defer f.Close()
+		in = f
+	} else {
+		fmt.Println("Paste the request headers of an authenticated POST to music.youtube.com, then press Ctrl-D")
+	}
+
+	headers, err := auth.ParseHeaders(in)
+	if err != nil {
+		return fmt.Errorf("auth browser: %w", err)
+	}
+
+	if _, err = auth.Save(session().credentialsPath, auth.Credentials{Kind: auth.KindBrowser, Headers: headers}); err != nil {
+		return fmt.Errorf("auth browser: %w", err)
+	}
+	fmt.Println("saved credentials to", session().credentialsPath)
+	return nil
+}
+
+func authStatus() error {
+	store, err := auth.Load(session().credentialsPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		if oauthToken != "" {
+			fmt.Println("using OAUTH_TOKEN from .env")
+			return nil
+		}
+		fmt.Println("not logged in")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("auth status: %w", err)
+	}
+
+	// This refreshes the token if it needs it
+	creds, err := store.Credentials(context.Background())
+	if err != nil {
+		return fmt.Errorf("auth status: %w", err)
+	}
+	fmt.Printf("%s credentials in %s\n", creds.Kind, store.Path())
+	if creds.Token != nil {
+		fmt.Println("token expires", time.Unix(creds.Token.ExpiresAt, 0).Format(time.RFC1123))
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lordxarus/ytmusic_cli/yt/cache"
+)
+
+// Used when the config doesn't set cacheSize
+const defaultCacheSize = 2 << 30
+
+func (s *profileSession) openCacheIndex() (*cache.Index, error) {
+	maxSize := int64(defaultCacheSize)
+	if cfg.CacheSize != "" {
+		var err error
+		if maxSize, err = parseSize(cfg.CacheSize); err != nil {
+			return nil, fmt.Errorf("profileSession.openCacheIndex(): bad cacheSize in config: %w", err)
+		}
+	}
+	index, err := cache.Open(s.cachePath, maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("profileSession.openCacheIndex(): %w", err)
+	}
+	return index, nil
+}
+
+// holdPlaying keeps what's playing, what's up next and videoIds from being
+// evicted while their files are read
+func holdPlaying(videoIds ...string) {
+	for _, p := range []*playing{nowPlaying.Load(), upNext.Load()} {
+		if p != nil {
+			videoIds = append(videoIds, p.song.VideoId)
+		}
+	}
+	session().cacheIndex.Hold(videoIds...)
+}
+
+func cacheCommand(args []string) error {
+	if len(args) == 0 {
+		args = []string{"ls"}
+	}
+
+	index, err := session().openCacheIndex()
+	if err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+
+	switch args[0] {
+	case "ls":
+		for _, entry := range index.Entries() {
+			marker := " "
+			if entry.Pinned {
+				marker = "*"
+			}
+			title := entry.Title
+			if entry.Author != "" {
+				title += " - " + entry.Author
+			}
+			fmt.Printf("%s %s\t%8s\t%s\t%s\n", marker, entry.VideoId, formatSize(entry.Size),
+				entry.LastPlayed.Format(time.DateTime), title)
+		}
+		return nil
+
+	case "du":
+		var pinned int64
+		entries := index.Entries()
+		for _, entry := range entries {
+			if entry.Pinned {
+				pinned += entry.Size
+			}
+		}
+		limit := "no limit"
+		if index.MaxSize() > 0 {
+			limit = formatSize(index.MaxSize())
+		}
+		fmt.Printf("%s in %d tracks (%s pinned), limit %s\n%s\n",
+			formatSize(index.Size()), len(entries), formatSize(pinned), limit, index.Dir())
+		return nil
+
+	case "prune":
+		flags := flag.NewFlagSet("cache prune", flag.ExitOnError)
+		maxSize := flags.String("max", "", "size to prune down to, defaults to cacheSize from the config")
+		flags.Parse(args[1:])
+		limit := index.MaxSize()
+		if *maxSize != "" {
+			if limit, err = parseSize(*maxSize); err != nil {
+				return fmt.Errorf("cache prune: %w", err)
+			}
+		}
+		if limit <= 0 {
+			return errors.New("cache prune: no size limit, pass -max or set cacheSize in the config")
+		}
+		evicted, err := index.Prune(limit)
+		for _, entry := range evicted {
+			fmt.Printf("evicted %s\t%s\n", entry.VideoId, entry.Title)
+		}
+		if err != nil {
+			return fmt.Errorf("cache prune: %w", err)
+		}
+		fmt.Printf("evicted %d tracks, cache is %s\n", len(evicted), formatSize(index.Size()))
+		return nil
+
+	case "pin", "unpin":
+		if len(args) < 2 {
+			return fmt.Errorf("cache %s: expected at least one video ID", args[0])
+		}
+		for _, id := range args[1:] {
+			if err = index.Pin(id, args[0] == "pin"); err != nil {
+				return fmt.Errorf("cache %s: %w", args[0], err)
+			}
+		}
+		return nil
+
+	case "verify":
+		flags := flag.NewFlagSet("cache verify", flag.ExitOnError)
+		fix := flags.Bool("fix", false, "delete bad downloads so they're fetched again")
+		flags.Parse(args[1:])
+		bad, err := index.Verify(*fix)
+		for id, problem := range bad {
+			fmt.Printf("%s\t%s\n", id, problem)
+		}
+		if err != nil {
+			return fmt.Errorf("cache verify: %w", err)
+		}
+		switch {
+		case len(bad) == 0:
+			fmt.Println("all downloads ok")
+		case *fix:
+			fmt.Printf("removed %d bad downloads\n", len(bad))
+		default:
+			return fmt.Errorf("cache verify: %d bad downloads, run with -fix to remove them", len(bad))
+		}
+		return nil
+	}
+	return fmt.Errorf("cache: unknown subcommand %q", args[0])
+}
+
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize reads sizes like 500MB or 1.5GB. Units are powers of 1024.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("parseSize(): bad size %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+func formatSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n >= u.size && u.size > 1 {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
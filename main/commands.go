@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Subcommands run instead of the TUI, e.g. ytmusic_cli upload ~/Music
+var commands = map[string]func(args []string) error{
+	"auth":    authCommand,
+	"cache":   cacheCommand,
+	"export":  exportCommand,
+	"profile": profileCommand,
+	"upload":  uploadCommand,
+}
+
+func runCommand(name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return cmd(args)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [--profile name] [command] [args]\n\ncommands:\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "  auth [oauth] [-client-id id] [-client-secret secret]  log in with the OAuth device flow")
+	fmt.Fprintln(os.Stderr, "  auth browser [-file headers.txt]     log in with headers copied from the browser")
+	fmt.Fprintln(os.Stderr, "  auth status|logout                   show or remove saved credentials")
+	fmt.Fprintln(os.Stderr, "  cache ls|du                          show what's in the download cache")
+	fmt.Fprintln(os.Stderr, "  cache prune [-max size]              evict old tracks until the cache fits")
+	fmt.Fprintln(os.Stderr, "  cache pin|unpin <videoId>...         keep tracks from being evicted")
+	fmt.Fprintln(os.Stderr, "  cache verify [-fix]                  check downloads are complete")
+	fmt.Fprintln(os.Stderr, "  export [-dir dir] [-codec copy|opus|mp3|aac|flac] [-jobs n] album <browseId>|playlist <id>|library")
+	fmt.Fprintln(os.Stderr, "                                       write tracks to dir as Artist/Album/NN Title, tagged")
+	fmt.Fprintln(os.Stderr, "  profile ls|add <name> [-brand id]|rm <name>|default <name>")
+	fmt.Fprintln(os.Stderr, "                                       manage account profiles")
+	fmt.Fprintln(os.Stderr, "  upload <dir>                         upload audio files in dir to your library")
+	fmt.Fprintln(os.Stderr, "  upload ls [songs|albums|artists]     list uploads")
+	fmt.Fprintln(os.Stderr, "  upload rm <entityId>...              delete uploads")
+	fmt.Fprintln(os.Stderr, "\nwith no command the player is started")
+}
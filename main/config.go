@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lordxarus/ytmusic_cli/internal/atomicfile"
+	"github.com/lordxarus/ytmusic_cli/yt"
+)
+
+// How long saveSoon() waits for the config to stop changing
+const configSaveDelay = time.Second
+
+// config is configPath/config.json. Everything in it is optional.
+type config struct {
+	// Used when --profile isn't given
+	DefaultProfile string             `json:"defaultProfile,omitempty"`
+	Profiles       map[string]profile `json:"profiles,omitempty"`
+	// How to draw album art: auto, kitty, sixel, halfblock or none
+	Art string `json:"art,omitempty"`
+	// Download quality: high, medium or low
+	Quality string `json:"quality,omitempty"`
+	// Preferred audio codec: opus or aac
+	Codec string `json:"codec,omitempty"`
+	// Largest the download cache can get before old tracks are evicted,
+	// e.g. 500MB or 2GB. 0 means no limit.
+	CacheSize string `json:"cacheSize,omitempty"`
+	// How many upcoming tracks to download in the background and how many
+	// at once. 0 uses the defaults, a negative Prefetch turns it off.
+	Prefetch        int `json:"prefetch,omitempty"`
+	PrefetchWorkers int `json:"prefetchWorkers,omitempty"`
+	// Which downloader to try first, kkdai or yt-dlp. The other is used if
+	// it fails.
+	Downloader string `json:"downloader,omitempty"`
+	// Where yt-dlp is, found on PATH if empty
+	YtDlpPath string `json:"ytDlpPath,omitempty"`
+	// Seconds to crossfade between tracks, 0 plays them back to back.
+	// Tracks that follow on from each other on an album are never faded.
+	Crossfade float64 `json:"crossfade,omitempty"`
+	// Loudness normalisation: off, track or album. Album mode plays an
+	// album's tracks at the same gain so quiet tracks stay quiet.
+	Normalize string `json:"normalize,omitempty"`
+	// dB added to the normalised gain, it's still held down to stop clipping
+	Preamp float64 `json:"preamp,omitempty"`
+	// Equaliser preset: flat, bass boost, vocal, loudness or one of
+	// EQPresets, which can also replace the built in ones
+	EQ        string              `json:"eq,omitempty"`
+	EQPresets map[string][]eqBand `json:"eqPresets,omitempty"`
+}
+
+// formatPolicy is the download format policy from the config
+func (c *config) formatPolicy() yt.FormatPolicy {
+	quality, err := yt.ParseQuality(c.Quality)
+	if err != nil {
+		log.Printf("config.formatPolicy(): %s, using high", err)
+	}
+	codec := strings.ToLower(c.Codec)
+	if codec != "" && codec != yt.CodecOpus && codec != yt.CodecAAC {
+		log.Printf("config.formatPolicy(): unknown codec %q, expected opus or aac", c.Codec)
+		codec = ""
+	}
+	return yt.FormatPolicy{Quality: quality, Codec: codec}
+}
+
+// downloaders are the downloaders from the config, preferred first
+func (c *config) downloaders() []yt.Downloader {
+	downloaders, err := yt.ParseDownloaders(c.Downloader, c.YtDlpPath)
+	if err != nil {
+		log.Printf("config.downloaders(): %s, using kkdai", err)
+	}
+	return downloaders
+}
+
+// normalize is the loudness normalisation mode from the config
+func (c *config) normalize() string {
+	mode, err := parseNormalize(c.Normalize)
+	if err != nil {
+		log.Printf("config.normalize(): %s, not normalising", err)
+	}
+	return mode
+}
+
+// crossfade is how long to crossfade between tracks, 0 for none
+func (c *config) crossfade() time.Duration {
+	if c.Crossfade < 0 {
+		log.Printf("config.crossfade(): %v is negative, not crossfading", c.Crossfade)
+		return 0
+	}
+	return time.Duration(c.Crossfade * float64(time.Second))
+}
+
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("loadConfig(): %w", err)
+	}
+	if err = json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("loadConfig(): failed to decode %s: %w", path, err)
+	}
+	for name, bands := range cfg.EQPresets {
+		for i := range bands {
+			if err = bands[i].check(float64(SpeakerSampleRate)); err != nil {
+				return nil, fmt.Errorf("loadConfig(): EQ preset %q band %d: %w", name, i+1, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+func (c *config) save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("config.save(): %w", err)
+	}
+	// Anything saveSoon() was holding on to is out of date
+	pendingConfig.cancel()
+	if err = writeConfig(path, data); err != nil {
+		return fmt.Errorf("config.save(): %w", err)
+	}
+	return nil
+}
+
+// saveSoon saves the config once it's stopped changing for a moment, for
+// settings like the EQ sliders that change a step at a time. Call
+// flushConfig() before exiting.
+func (c *config) saveSoon(path string) error {
+	// Encoded now, the config can change again while it waits
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("config.saveSoon(): %w", err)
+	}
+	pendingConfig.set(path, data)
+	return nil
+}
+
+// flushConfig writes what saveSoon() is waiting to
+func flushConfig() error {
+	if err := pendingConfig.flush(); err != nil {
+		return fmt.Errorf("flushConfig(): %w", err)
+	}
+	return nil
+}
+
+func writeConfig(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data, 0o600)
+}
+
+// delayedSave holds the latest config saveSoon() was given until it's
+// written
+type delayedSave struct {
+	mu    sync.Mutex
+	path  string
+	data  []byte
+	timer *time.Timer
+}
+
+var pendingConfig delayedSave
+
+func (d *delayedSave) set(path string, data []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.path, d.data = path, data
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.timer = time.AfterFunc(configSaveDelay, func() {
+		if err := d.flush(); err != nil {
+			log.Printf("delayedSave: %s", err)
+		}
+	})
+}
+
+func (d *delayedSave) flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.data == nil {
+		return nil
+	}
+	path, data := d.path, d.data
+	d.data = nil
+	return writeConfig(path, data)
+}
+
+func (d *delayedSave) cancel() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.data = nil
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveSoon(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	c := &config{}
+	for _, eq := range []string{"vocal", "loudness", "bass boost"} {
+		c.EQ = eq
+		if err := c.saveSoon(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := flushConfig(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.EQ != "bass boost" {
+		t.Fatalf("saved EQ is %q, want the last one", saved.EQ)
+	}
+
+	// A save straight away replaces one that's waiting
+	c.EQ = "vocal"
+	if err = c.saveSoon(path); err != nil {
+		t.Fatal(err)
+	}
+	c.EQ = "flat"
+	if err = c.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if err = flushConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err = loadConfig(path); err != nil || saved.EQ != "flat" {
+		t.Fatalf("saved EQ is %q, %v, want flat", saved.EQ, err)
+	}
+}
+
+func TestCheckBands(t *testing.T) {
+	rate := float64(SpeakerSampleRate)
+	band := eqBand{Type: "LowShelf", Freq: 100, Gain: 30}
+	if err := band.check(rate); err != nil {
+		t.Fatal(err)
+	}
+	if band.Type != bandLowShelf || band.Gain != maxBandGain {
+		t.Fatalf("checked band is %+v, want a lowshelf at %vdB", band, maxBandGain)
+	}
+	for _, bad := range []eqBand{
+		{Type: "notch", Freq: 100},
+		{Type: bandPeak, Freq: 0},
+		{Type: bandPeak, Freq: rate / 2},
+	} {
+		if err := bad.check(rate); err == nil {
+			t.Errorf("%+v passed the check", bad)
+		}
+	}
+	if got := slider(-40); []rune(got)[0] != '●' {
+		t.Errorf("slider(-40) is %s, want the knob at the left end", got)
+	}
+}
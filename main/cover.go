@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"log"
+	"sync"
+
+	"code.rocketnine.space/tslocum/cview"
+	"github.com/gdamore/tcell/v2"
+	"github.com/lordxarus/ytmusic_cli/internal/art"
+	"github.com/lordxarus/ytmusic_cli/yt"
+)
+
+// Ask for thumbnails at least this wide, big enough for a sixel cover in a
+// maximised terminal without being slow to fetch
+const coverSize = 544
+
+// coverView shows album art. Half blocks are drawn through tcell like any
+// other text. Kitty and sixel images are written straight to the tty once
+// tcell is done drawing and the cells under them are locked so tcell doesn't
+// paint over them.
+type coverView struct {
+	*cview.Box
+	protocol art.Protocol
+	cache    *art.Cache
+	// Kitty image ID
+	id uint32
+
+	mu  sync.Mutex
+	url string
+	img image.Image
+	// Cells inside the border, set on every draw
+	area image.Rectangle
+
+	// What was last written to the tty, only touched by afterDraw
+	placedImg    image.Image
+	placedArea   image.Rectangle
+	placedScreen image.Point
+	locked       image.Rectangle
+}
+
+func newCoverView(protocol art.Protocol, cache *art.Cache) *coverView {
+	v := &coverView{
+		Box:      cview.NewBox(),
+		protocol: protocol,
+		cache:    cache,
+		id:       1,
+	}
+	v.SetBorder(true)
+	v.SetDrawFunc(v.draw)
+	return v
+}
+
+// enabled is false when there's no art to draw, the view isn't laid out then
+func (v *coverView) enabled() bool {
+	return v.protocol != art.None
+}
+
+// show fetches the best of thumbnails in the background and draws it. No
+// thumbnails clears the view.
+func (v *coverView) show(title string, thumbnails []yt.Thumbnail) {
+	if !v.enabled() {
+		return
+	}
+	url := art.Pick(thumbnails, coverSize)
+
+	// Called from playback goroutines as well as the UI
+	app.QueueUpdateDraw(func() { v.SetTitle(title) })
+
+	v.mu.Lock()
+	if url == v.url {
+		v.mu.Unlock()
+		return
+	}
+	v.url = url
+	v.img = nil
+	v.mu.Unlock()
+
+	if url == "" {
+		return
+	}
+	go func() {
+		img, err := v.cache.Get(url)
+		if err != nil {
+			log.Printf("coverView.show(): %s", err)
+			return
+		}
+		v.mu.Lock()
+		// Something else may have been shown while we were downloading
+		if v.url == url {
+			v.img = img
+		}
+		v.mu.Unlock()
+		app.Draw()
+	}()
+}
+
+func (v *coverView) draw(screen tcell.Screen, x, y, width, height int) (int, int, int, int) {
+	// Inside the border
+	x, y, width, height = x+1, y+1, width-2, height-2
+
+	v.mu.Lock()
+	v.area = image.Rect(x, y, x+max(width, 0), y+max(height, 0))
+	img := v.img
+	v.mu.Unlock()
+
+	if v.protocol == art.HalfBlock && img != nil {
+		art.DrawHalfBlock(screen, img, x, y, width, height)
+	}
+	return x, y, width, height
+}
+
+// afterDraw places kitty and sixel images. It has to run after tcell has
+// flushed the screen, see cview.Application.SetAfterDrawFunc().
+func (v *coverView) afterDraw(screen tcell.Screen) {
+	if v.protocol != art.Kitty && v.protocol != art.Sixel {
+		return
+	}
+
+	v.mu.Lock()
+	img, area := v.img, v.area
+	v.mu.Unlock()
+
+	// A resize makes tcell repaint everything, wiping out sixels
+	w, h := screen.Size()
+	size := image.Pt(w, h)
+	if img == v.placedImg && area == v.placedArea && size == v.placedScreen {
+		return
+	}
+	tty, ok := screen.Tty()
+	if !ok {
+		return
+	}
+
+	// Take down the old image. Unlocking marks the cells dirty so Show()
+	// paints over what's left of a sixel.
+	if !v.locked.Empty() {
+		screen.LockRegion(v.locked.Min.X, v.locked.Min.Y, v.locked.Dx(), v.locked.Dy(), false)
+		v.locked = image.Rectangle{}
+	}
+	if v.protocol == art.Kitty {
+		if err := art.DeleteKitty(tty, v.id); err != nil {
+			log.Printf("coverView.afterDraw(): %s", err)
+		}
+	}
+	screen.Show()
+
+	v.placedImg, v.placedArea, v.placedScreen = img, area, size
+	if img == nil || area.Empty() {
+		return
+	}
+
+	cellWidth, cellHeight := art.CellSize(tty)
+	cells, pixels := art.Layout(img.Bounds().Size(), area.Dx(), area.Dy(), cellWidth, cellHeight)
+	cells = cells.Add(area.Min)
+
+	var buf bytes.Buffer
+	// Save the cursor, move to the top left of the image and put it back
+	// after so tcell doesn't lose track of where it is
+	fmt.Fprintf(&buf, "\x1b7\x1b[%d;%dH", cells.Min.Y+1, cells.Min.X+1)
+	var err error
+	if v.protocol == art.Kitty {
+		err = art.WriteKitty(&buf, img, v.id, cells.Dx(), cells.Dy())
+	} else {
+		err = art.WriteSixel(&buf, art.Scale(img, pixels.X, pixels.Y))
+	}
+	if err != nil {
+		log.Printf("coverView.afterDraw(): %s", err)
+		return
+	}
+	buf.WriteString("\x1b8")
+
+	if _, err = tty.Write(buf.Bytes()); err != nil {
+		log.Printf("coverView.afterDraw(): %s", err)
+		return
+	}
+	screen.LockRegion(cells.Min.X, cells.Min.Y, cells.Dx(), cells.Dy(), true)
+	v.locked = cells
+}
+
+func createAlbumList(albums []yt.Album, openFunc func(yt.Album)) *cview.List {
+	albumList := cview.NewList()
+	for _, album := range albums {
+		album := album
+		li := cview.NewListItem(album.Name)
+		secondary := fmt.Sprintf("%s - %s", yt.Names(album.Artists), album.Type)
+		if album.Year > 0 {
+			secondary += fmt.Sprintf(" %d", album.Year)
+		}
+		li.SetSecondaryText(secondary)
+		li.SetReference(album)
+		li.SetSelectedFunc(func() { openFunc(album) })
+		albumList.AddItem(li)
+	}
+	return albumList
+}
+
+// coverProtocol works out how to draw art from the config
+func coverProtocol() art.Protocol {
+	protocol, err := art.ParseProtocol(cfg.Art)
+	if err != nil {
+		log.Printf("coverProtocol(): %s, detecting instead", err)
+	}
+	return protocol.Resolve()
+}
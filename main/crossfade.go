@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+
+	"github.com/faiface/beep"
+	"github.com/lordxarus/ytmusic_cli/yt"
+)
+
+// fader fades Streamer in or out with an equal-power curve, the way
+// effects.Volume sets its volume. Progress goes from 0 to 1 by Step a
+// sample. It passes samples through untouched unless Active.
+type fader struct {
+	Streamer beep.Streamer
+	Active   bool
+	In       bool
+	Progress float64
+	Step     float64
+}
+
+func (f *fader) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = f.Streamer.Stream(samples)
+	if !f.Active {
+		return n, ok
+	}
+	for i := range samples[:n] {
+		gain := f.gain()
+		samples[i][0] *= gain
+		samples[i][1] *= gain
+		f.Progress += f.Step
+	}
+	return n, ok
+}
+
+func (f *fader) Err() error {
+	return f.Streamer.Err()
+}
+
+// gain is sin for fading in and cos for fading out, so the two add up to
+// the same power all the way through a crossfade
+func (f *fader) gain() float64 {
+	x := min(max(f.Progress, 0), 1) * math.Pi / 2
+	if f.In {
+		return math.Sin(x)
+	}
+	return math.Cos(x)
+}
+
+// contiguous is true when next carries straight on from current on the same
+// album, e.g. a live album or the movements of a symphony, which shouldn't
+// be crossfaded
+func contiguous(current, next yt.Track) bool {
+	if current.Album.ID == "" || current.Album.ID != next.Album.ID {
+		return false
+	}
+	if current.TrackNumber == 0 || next.TrackNumber == 0 {
+		// Same album and next in the queue is as good as it gets
+		return true
+	}
+	return next.TrackNumber == current.TrackNumber+1
+}
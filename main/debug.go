@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"code.rocketnine.space/tslocum/cview"
+	"github.com/lordxarus/ytmusic_cli/yt"
+	"github.com/lordxarus/ytmusic_cli/yt/prefetch"
+)
+
+// createDebugList shows prefetch stats and then decode warnings newest
+// first so a partial break in ytmusicapi's output is easy to spot
+func createDebugList(warnings []yt.Warning, stats prefetch.Stats) *cview.List {
+	warningList := cview.NewList()
+
+	li := cview.NewListItem(fmt.Sprintf("prefetch: %d hits, %d late, %d misses", stats.Hits, stats.Late, stats.Misses))
+	li.SetSecondaryText(fmt.Sprintf("%d fetched, %d failed, %d cancelled", stats.Fetched, stats.Failed, stats.Cancelled))
+	li.SetReference(stats)
+	warningList.AddItem(li)
+
+	if len(warnings) == 0 {
+		warningList.AddItem(cview.NewListItem("no decode warnings"))
+		return warningList
+	}
+
+	for i := len(warnings) - 1; i >= 0; i-- {
+		w := warnings[i]
+		action := "kept"
+		if w.Skipped {
+			action = "skipped"
+		}
+		li := cview.NewListItem(fmt.Sprintf("%s %s %s (%s)", w.Time.Format("15:04:05"), w.Endpoint, w.Path, action))
+		li.SetSecondaryText(w.Message)
+		li.SetReference(w)
+		warningList.AddItem(li)
+	}
+	return warningList
+}
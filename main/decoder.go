@@ -0,0 +1,362 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/lordxarus/ytmusic_cli/internal/audio"
+	"github.com/zergon321/reisen"
+)
+
+var errNotSeekable = errors.New("can't seek while the track is still downloading")
+
+// Passed to beep.Resample, 4 is what beep suggests for music
+const resampleQuality = 4
+
+// How many samples beep.Resample reads at a time. It takes a short read for
+// the end of the track.
+const resampleBlock = 512
+
+// sourceFormat is what the decoder hands over. reisen converts whatever
+// sample format and channel layout the codec produces to interleaved
+// stereo float64, libswresample doing any mixing, but keeps the stream's
+// own rate, which is 48kHz for most of YouTube.
+type sourceFormat struct {
+	rate beep.SampleRate
+}
+
+// mediaStreamer is a beep.StreamSeekCloser over a file reisen can open. The
+// audio is decoded in the background into a buffer, seeking stops the
+// decoder, rewinds the file and starts decoding again from there.
+//
+// Positions are in samples at SpeakerSampleRate from the start of the
+// track. Like beep's own streamers it isn't safe for concurrent use, Seek()
+// and Close() need the speaker lock while it's playing.
+type mediaStreamer struct {
+	media *reisen.Media
+	audio *reisen.AudioStream
+	// See loadAudio()
+	streaming bool
+	// 0 if the container doesn't say
+	length int
+	format sourceFormat
+
+	samples *audio.Ring
+	// Reads samples, resampled to SpeakerSampleRate if need be
+	out  beep.Streamer
+	stop chan struct{}
+	done chan struct{}
+	// Why the decoder gave up, set before done is closed
+	failed   *error
+	position int
+	closed   bool
+}
+
+// loadAudio opens path and decodes it in the background from from.
+// streaming is set for a download being streamed through a fifo, which
+// can't seek. The decoder reads its way up to from instead and the streamer
+// plays silence whenever it has nothing decoded, rather than holding up the
+// speaker.
+func loadAudio(path string, from time.Duration, streaming bool) (*mediaStreamer, error) {
+	media, err := reisen.NewMedia(path)
+	if err != nil {
+		return nil, fmt.Errorf("loadAudio(): Unable to create new media %w", err)
+	}
+	audio, err := openAudio(media)
+	if err != nil {
+		media.Close()
+		return nil, fmt.Errorf("loadAudio(): %w", err)
+	}
+
+	s := &mediaStreamer{
+		media:     media,
+		audio:     audio,
+		streaming: streaming,
+		format:    sourceFormat{rate: beep.SampleRate(audio.SampleRate())},
+	}
+	if s.format.rate <= 0 {
+		log.Printf("loadAudio(): %s has no sample rate, assuming %d", path, SpeakerSampleRate)
+		s.format.rate = SpeakerSampleRate
+	}
+	log.Printf("loadAudio(): %s, %d Hz, %d channel(s), playing at %d Hz",
+		audio.CodecName(), s.format.rate, audio.ChannelCount(), SpeakerSampleRate)
+	if duration, err := media.Duration(); err == nil {
+		s.length = SpeakerSampleRate.N(duration)
+	}
+	if err = s.start(SpeakerSampleRate.N(from), from > 0 && !streaming, nil); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("loadAudio(): %w", err)
+	}
+	return s, nil
+}
+
+// openAudio opens media for decoding and returns its first audio stream
+func openAudio(media *reisen.Media) (*reisen.AudioStream, error) {
+	if err := media.OpenDecode(); err != nil {
+		return nil, fmt.Errorf("openAudio(): open decode failed: %w", err)
+	}
+
+	var audioStream *reisen.AudioStream
+	log.Printf("found %d stream(s)", media.StreamCount())
+	for _, stream := range media.Streams() {
+		if stream.Type() == reisen.StreamAudio {
+			audioStream = stream.(*reisen.AudioStream)
+			break
+		}
+	}
+	if audioStream == nil {
+		media.CloseDecode()
+		return nil, errors.New("openAudio(): no audio stream")
+	}
+
+	if err := audioStream.Open(); err != nil {
+		media.CloseDecode()
+		return nil, fmt.Errorf("openAudio(): failed to open audio stream: %w", err)
+	}
+	return audioStream, nil
+}
+
+func (s *mediaStreamer) Stream(samples [][2]float64) (n int, ok bool) {
+	if s.closed {
+		return 0, false
+	}
+	if s.streaming && !s.decoded() {
+		return s.streamBuffered(samples), true
+	}
+
+	n, ok = s.out.Stream(samples)
+	s.position += n
+	return n, ok
+}
+
+// streamBuffered streams what's been decoded so far of a download that's
+// still going, without waiting on the decoder with the speaker locked. The
+// rest is silence while it waits on the download, or gets to where playback
+// starts. The silence isn't part of the track, the position stays put.
+func (s *mediaStreamer) streamBuffered(samples [][2]float64) int {
+	var n int
+	if s.format.rate == SpeakerSampleRate {
+		n, _ = s.samples.ReadAvailable(samples)
+	} else if s.samples.Len() >= s.format.rate.N(SpeakerSampleRate.D(len(samples)))+2*resampleBlock {
+		// The resampler only reads once there's enough for all of samples
+		n, _ = s.out.Stream(samples)
+	}
+	clear(samples[n:])
+	s.position += n
+	return len(samples)
+}
+
+// read is the decoder's samples at their own rate
+func (s *mediaStreamer) read(samples [][2]float64) (n int, ok bool) {
+	return s.samples.Read(samples)
+}
+
+// decoded is true once the decoder has nothing more to add
+func (s *mediaStreamer) decoded() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Err is what stopped the decoder short of the end of the track, once it
+// has stopped
+func (s *mediaStreamer) Err() error {
+	if s.failed == nil || !s.decoded() {
+		return nil
+	}
+	return *s.failed
+}
+
+func (s *mediaStreamer) Len() int {
+	return s.length
+}
+
+func (s *mediaStreamer) Position() int {
+	return s.position
+}
+
+func (s *mediaStreamer) Seek(p int) error {
+	if s.closed {
+		return errors.New("mediaStreamer.Seek(): closed")
+	}
+	if s.streaming {
+		return fmt.Errorf("mediaStreamer.Seek(): %w", errNotSeekable)
+	}
+	if s.length > 0 {
+		p = min(p, s.length)
+	}
+	// The decoder rewinds once the old one is done with the file, which
+	// mustn't be waited for here with the speaker locked
+	if err := s.start(max(p, 0), true, s.halt()); err != nil {
+		return fmt.Errorf("mediaStreamer.Seek(): %w", err)
+	}
+	return nil
+}
+
+func (s *mediaStreamer) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.done == nil {
+		s.release()
+		return nil
+	}
+	// The decoder may be blocked reading a fifo, let go of the file once
+	// it's done rather than holding up the speaker lock
+	done := s.halt()
+	go func() {
+		<-done
+		s.release()
+	}()
+	return nil
+}
+
+// release closes the file, the decoder must have stopped
+func (s *mediaStreamer) release() {
+	s.audio.Close()
+	s.media.CloseDecode()
+	s.media.Close()
+}
+
+// start decodes from p in the background, rewinding the file first if
+// rewind is set. previous is the decoder being replaced, if there is one.
+// The rewind waits in the background for it to let go of the file, a
+// failure then ends the track.
+func (s *mediaStreamer) start(p int, rewind bool, previous <-chan struct{}) error {
+	target := SpeakerSampleRate.D(p)
+	if rewind && previous == nil {
+		if err := s.audio.Rewind(target); err != nil {
+			return fmt.Errorf("failed to seek to %s: %w", target, err)
+		}
+	}
+	s.samples = audio.NewRing(sampleBufferSize)
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	s.failed = new(error)
+	s.position = p
+	// A fresh resampler, the old one has samples from before the seek
+	s.out = beep.StreamerFunc(s.read)
+	if s.format.rate != SpeakerSampleRate {
+		s.out = beep.Resample(resampleQuality, s.format.rate, SpeakerSampleRate, s.out)
+	}
+
+	samples, stop, done, failed := s.samples, s.stop, s.done, s.failed
+	go func() {
+		// done goes first so Err() has the error by the time Stream() runs
+		// out of samples
+		defer samples.Close()
+		defer close(done)
+		if previous != nil {
+			<-previous
+			if rewind {
+				if err := s.audio.Rewind(target); err != nil {
+					log.Printf("mediaStreamer.start(): failed to seek to %s: %s", target, err)
+					*failed = fmt.Errorf("mediaStreamer.start(): failed to seek to %s: %w", target, err)
+					return
+				}
+			}
+		}
+		if err := decodeAudio(s.media, s.format, target, SpeakerSampleRate.D(s.length), samples, stop); err != nil {
+			log.Printf("Decoding error: %s", err)
+			*failed = err
+		}
+	}()
+	return nil
+}
+
+// halt tells the decoder to stop and returns a channel that's closed once
+// it has let go of the file
+func (s *mediaStreamer) halt() <-chan struct{} {
+	close(s.stop)
+	// Wakes the decoder if it's waiting for room
+	s.samples.Close()
+	return s.done
+}
+
+// https://medium.com/@maximgradan/playing-videos-with-golang-83e67447b111
+// decodeAudio reads audio frames from media and writes them, already
+// stereo, to samples a frame at a time until the file ends or stop is
+// closed, returning an error if it couldn't read on. Rewinding lands on
+// the frame before from, samples before it are dropped.
+//
+// Encoder padding has to go for tracks to splice cleanly on the deck.
+// libavcodec drops the priming samples at the start, Opus pre-skip and the
+// packets' skip samples side data. The padding on the end is cut off here
+// at length, the container's duration, which leaves it out. length is 0
+// when the container doesn't say.
+func decodeAudio(media *reisen.Media, format sourceFormat, from, length time.Duration, samples *audio.Ring, stop <-chan struct{}) error {
+	defer log.Printf("decodeAudio(): decoder goroutine: exiting")
+
+	start, end := format.rate.N(from), format.rate.N(length)
+	// Where in the track the next frame starts, worked out from the first
+	at := -1
+	// Reused for every frame
+	var block [][2]float64
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		packet, gotPacket, err := media.ReadPacket()
+		if err != nil {
+			return fmt.Errorf("decodeAudio(): failed to read media packet: %w", err)
+		}
+
+		if !gotPacket {
+			log.Printf("decodeAudio(): no packet")
+			return nil
+		}
+		if packet == nil {
+			// Nothing ready yet, try again
+			continue
+		}
+
+		if packet.Type() == reisen.StreamAudio {
+			s := media.Streams()[packet.StreamIndex()].(*reisen.AudioStream)
+
+			audioFrame, gotFrame, err := s.ReadAudioFrame()
+			if err != nil {
+				return fmt.Errorf("decodeAudio(): failed to read audio frame: %w", err)
+			}
+
+			if !gotFrame {
+				log.Printf("decodeAudio(): gotFrame is false, end of stream")
+				return nil
+			}
+			if audioFrame == nil {
+				// reisen skips frames it can't use yet after a seek
+				continue
+			}
+
+			if at < 0 {
+				// Without a timestamp the rewind is taken to have landed on from
+				at = start
+				if offset, err := audioFrame.PresentationOffset(); err == nil {
+					at = format.rate.N(offset)
+				}
+			}
+
+			block = audio.AppendStereo(block[:0], audioFrame.Data())
+			out := audio.Window(block, at, start, end)
+			at += len(block)
+			// Blocks while paused, the ring is full
+			if !samples.Write(out) {
+				return nil
+			}
+			if end > 0 && at >= end {
+				// Only padding left
+				return nil
+			}
+		}
+	}
+}
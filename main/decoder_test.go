@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/lordxarus/ytmusic_cli/internal/audio"
+)
+
+// streamingStreamer is a mediaStreamer part way through a download, its
+// decoder still going
+func streamingStreamer(rate beep.SampleRate) *mediaStreamer {
+	s := &mediaStreamer{
+		streaming: true,
+		format:    sourceFormat{rate: rate},
+		samples:   audio.NewRing(sampleBufferSize),
+		done:      make(chan struct{}),
+	}
+	s.out = beep.StreamerFunc(s.read)
+	if rate != SpeakerSampleRate {
+		s.out = beep.Resample(resampleQuality, rate, SpeakerSampleRate, s.out)
+	}
+	return s
+}
+
+// streamWithin fails if Stream() waits on the decoder
+func streamWithin(t *testing.T, s *mediaStreamer, samples [][2]float64) int {
+	t.Helper()
+	streamed := make(chan int)
+	go func() {
+		n, _ := s.Stream(samples)
+		streamed <- n
+	}()
+	select {
+	case n := <-streamed:
+		return n
+	case <-time.After(time.Second):
+		t.Fatal("Stream() waited on the decoder")
+		return 0
+	}
+}
+
+func TestStreamPartlyBuffered(t *testing.T) {
+	s := streamingStreamer(SpeakerSampleRate)
+	buffered := make([][2]float64, 10)
+	for i := range buffered {
+		buffered[i] = [2]float64{1, 1}
+	}
+	s.samples.Write(buffered)
+
+	samples := make([][2]float64, 25)
+	for i := range samples {
+		samples[i] = [2]float64{9, 9}
+	}
+	if n := streamWithin(t, s, samples); n != len(samples) {
+		t.Fatalf("streamed %d, want %d", n, len(samples))
+	}
+	for i, sample := range samples {
+		want := [2]float64{1, 1}
+		if i >= 10 {
+			want = [2]float64{}
+		}
+		if sample != want {
+			t.Fatalf("sample %d is %v, want %v", i, sample, want)
+		}
+	}
+	// Only what was decoded counts
+	if s.Position() != 10 {
+		t.Fatalf("position is %d, want 10", s.Position())
+	}
+}
+
+func TestStreamPartlyBufferedResampled(t *testing.T) {
+	s := streamingStreamer(48000)
+	s.samples.Write(make([][2]float64, 100))
+
+	// Too little for the resampler to read without coming up short
+	samples := make([][2]float64, 512)
+	if n := streamWithin(t, s, samples); n != len(samples) {
+		t.Fatalf("streamed %d, want %d", n, len(samples))
+	}
+	if s.Position() != 0 || s.samples.Len() != 100 {
+		t.Fatalf("position is %d with %d buffered, want 0 with 100", s.Position(), s.samples.Len())
+	}
+
+	s.samples.Write(make([][2]float64, 4096))
+	if n := streamWithin(t, s, samples); n != len(samples) {
+		t.Fatalf("streamed %d, want %d", n, len(samples))
+	}
+	if s.Position() != len(samples) {
+		t.Fatalf("position is %d, want %d", s.Position(), len(samples))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"code.rocketnine.space/tslocum/cview"
+	"github.com/lordxarus/ytmusic_cli/internal/atomicfile"
+	"github.com/lordxarus/ytmusic_cli/yt"
+)
+
+// An episode counts as played once we're this far through it
+const playedThreshold = 0.95
+
+type episodeProgress struct {
+	Position  time.Duration `json:"position"`
+	Length    time.Duration `json:"length"`
+	Played    bool          `json:"played"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+}
+
+// episodeStore remembers how far into each episode we got
+type episodeStore struct {
+	path     string
+	mu       sync.Mutex
+	progress map[string]episodeProgress
+}
+
+func loadEpisodeStore(path string) (*episodeStore, error) {
+	store := &episodeStore{
+		path:     path,
+		progress: make(map[string]episodeProgress),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("loadEpisodeStore(): %w", err)
+	}
+	if err = json.Unmarshal(data, &store.progress); err != nil {
+		return nil, fmt.Errorf("loadEpisodeStore(): failed to decode %s: %w", path, err)
+	}
+	return store, nil
+}
+
+func (es *episodeStore) get(videoId string) episodeProgress {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.progress[videoId]
+}
+
+// resumeAt is where playback of videoId should start. Played episodes
+// start from the beginning again.
+func (es *episodeStore) resumeAt(videoId string) time.Duration {
+	p := es.get(videoId)
+	if p.Played {
+		return 0
+	}
+	return p.Position
+}
+
+func (es *episodeStore) update(videoId string, position time.Duration, length time.Duration) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	p := es.progress[videoId]
+	p.Position = position
+	p.Length = length
+	p.UpdatedAt = time.Now()
+	if length > 0 && float64(position) >= float64(length)*playedThreshold {
+		p.Played = true
+		p.Position = 0
+	}
+	es.progress[videoId] = p
+	return es.save()
+}
+
+func (es *episodeStore) setPlayed(videoId string, played bool) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	p := es.progress[videoId]
+	p.Played = played
+	p.Position = 0
+	p.UpdatedAt = time.Now()
+	es.progress[videoId] = p
+	return es.save()
+}
+
+// save must be called with mu held
+func (es *episodeStore) save() error {
+	data, err := json.MarshalIndent(es.progress, "", "  ")
+	if err != nil {
+		return fmt.Errorf("episodeStore.save(): %w", err)
+	}
+	if err = atomicfile.WriteFile(es.path, data, 0o600); err != nil {
+		return fmt.Errorf("episodeStore.save(): %w", err)
+	}
+	return nil
+}
+
+// episodeLabel marks an episode as unplayed, in progress or played
+func episodeLabel(episode yt.Episode) string {
+	p := session().episodes.get(episode.VideoId)
+	length := p.Length
+	if length == 0 {
+		length = episode.Duration
+	}
+
+	switch {
+	case p.Played:
+		return "✓ " + episode.Title
+	case p.Position > 0 && length > 0:
+		return fmt.Sprintf("◐ %d%% %s", int(100*p.Position/length), episode.Title)
+	default:
+		return "● " + episode.Title
+	}
+}
+
+func createPodcastList(podcasts []yt.Podcast, openFunc func(yt.Podcast)) *cview.List {
+	podcastList := cview.NewList()
+	for _, podcast := range podcasts {
+		podcast := podcast
+		li := cview.NewListItem(podcast.Title)
+		li.SetSecondaryText(podcast.Author.Name)
+		li.SetReference(podcast)
+		li.SetSelectedFunc(func() { openFunc(podcast) })
+		podcastList.AddItem(li)
+	}
+	return podcastList
+}
+
+func createEpisodeList(episodes []yt.Episode, selectedFunc func()) *cview.List {
+	episodeList := cview.NewList()
+	for _, episode := range episodes {
+		li := cview.NewListItem(episodeLabel(episode))
+		li.SetSecondaryText(fmt.Sprintf("%s - %s %s", episode.Podcast.Name, yt.FormatDuration(episode.Duration), episode.Date))
+		li.SetReference(episode)
+		li.SetSelectedFunc(selectedFunc)
+		episodeList.AddItem(li)
+	}
+	return episodeList
+}
+
+// saveEpisodePosition records how far into the playing episode we are.
+// It's a no-op when a song is playing.
+func saveEpisodePosition() {
+	p := nowPlaying.Load()
+	if p == nil || p.episode == nil {
+		return
+	}
+	err := session().episodes.update(p.episode.VideoId, p.position.Position(), p.episode.Duration)
+	if err != nil {
+		log.Printf("saveEpisodePosition(): %s", err)
+	}
+	if p.item != nil {
+		p.item.SetMainText(episodeLabel(*p.episode))
+	}
+}
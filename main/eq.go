@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"code.rocketnine.space/tslocum/cview"
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/speaker"
+	"github.com/gdamore/tcell/v2"
+	"github.com/lordxarus/ytmusic_cli/internal/audio"
+)
+
+// Kinds of equaliser band
+const (
+	bandPeak      = "peak"
+	bandLowShelf  = "lowshelf"
+	bandHighShelf = "highshelf"
+)
+
+const (
+	defaultEQPreset = "flat"
+	// Adjusting a built in preset saves it as this
+	customEQPreset = "custom"
+	// Sliders go this far either way, in dB, a step at a time
+	maxBandGain  = 12.0
+	bandGainStep = 0.5
+	// Used when a band doesn't give a Q, a shelf without overshoot
+	defaultBandQ = math.Sqrt2 / 2
+)
+
+// eqBand is one filter of the equaliser
+type eqBand struct {
+	// peak, lowshelf or highshelf
+	Type string  `json:"type"`
+	Freq float64 `json:"freq"`
+	Q    float64 `json:"q,omitempty"`
+	// In dB
+	Gain float64 `json:"gain"`
+}
+
+// coefficients are the band's filter at rate
+func (b eqBand) coefficients(rate float64) ([3]float64, [3]float64) {
+	q := b.Q
+	if q <= 0 {
+		q = defaultBandQ
+	}
+	switch b.Type {
+	case bandLowShelf:
+		return audio.LowShelf(rate, b.Freq, q, b.Gain)
+	case bandHighShelf:
+		return audio.HighShelf(rate, b.Freq, q, b.Gain)
+	}
+	return audio.Peaking(rate, b.Freq, q, b.Gain)
+}
+
+// check normalises a band from the config, its gain is clamped to what the
+// sliders go to. It fails if the type is unknown or the band isn't between
+// 0Hz and half of rate, where the filters stop working.
+func (b *eqBand) check(rate float64) error {
+	switch b.Type = strings.ToLower(b.Type); b.Type {
+	case "":
+		b.Type = bandPeak
+	case bandPeak, bandLowShelf, bandHighShelf:
+	default:
+		return fmt.Errorf("unknown band type %q, expected peak, lowshelf or highshelf", b.Type)
+	}
+	if b.Freq <= 0 || b.Freq >= rate/2 {
+		return fmt.Errorf("%vHz is outside 0Hz to %vHz", b.Freq, rate/2)
+	}
+	if gain := clampBandGain(b.Gain); gain != b.Gain {
+		log.Printf("eqBand.check(): %s gain %+.1fdB is out of range, using %+.1fdB", b, b.Gain, gain)
+		b.Gain = gain
+	}
+	return nil
+}
+
+// clampBandGain keeps gain within the sliders' range
+func clampBandGain(gain float64) float64 {
+	return math.Max(-maxBandGain, math.Min(gain, maxBandGain))
+}
+
+func (b eqBand) String() string {
+	freq := fmt.Sprintf("%.0fHz", b.Freq)
+	if b.Freq >= 1000 {
+		freq = fmt.Sprintf("%.3gkHz", b.Freq/1000)
+	}
+	switch b.Type {
+	case bandLowShelf:
+		return freq + " low shelf"
+	case bandHighShelf:
+		return freq + " high shelf"
+	}
+	return freq
+}
+
+// The built in presets all use these bands with their own gains
+func eqBands(gains ...float64) []eqBand {
+	layout := []eqBand{
+		{Type: bandLowShelf, Freq: 100},
+		{Type: bandPeak, Freq: 300, Q: 1},
+		{Type: bandPeak, Freq: 1000, Q: 1},
+		{Type: bandPeak, Freq: 3000, Q: 1},
+		{Type: bandHighShelf, Freq: 8000},
+	}
+	for i := range layout {
+		layout[i].Gain = gains[i]
+	}
+	return layout
+}
+
+var builtinEQPresets = map[string][]eqBand{
+	"flat":       eqBands(0, 0, 0, 0, 0),
+	"bass boost": eqBands(6, 2, 0, 0, 0),
+	"vocal":      eqBands(-2, -1, 2, 3, 1),
+	// Lifts the ends the ear is less sensitive to at low volume
+	"loudness": eqBands(5, 1, -1, 1, 4),
+}
+
+// eqPresetNames lists the built in presets and then the config's, which
+// can replace a built in one by using its name
+func eqPresetNames() []string {
+	names := []string{"flat", "bass boost", "vocal", "loudness"}
+	var user []string
+	for name := range cfg.EQPresets {
+		if _, builtin := builtinEQPresets[name]; !builtin {
+			user = append(user, name)
+		}
+	}
+	sort.Strings(user)
+	return append(names, user...)
+}
+
+// eqPreset returns a copy of the named preset's bands
+func eqPreset(name string) ([]eqBand, bool) {
+	bands, ok := cfg.EQPresets[name]
+	if !ok {
+		bands, ok = builtinEQPresets[name]
+	}
+	return append([]eqBand(nil), bands...), ok
+}
+
+// equalizer filters what the deck plays before the volume effect. Bands
+// can be changed while it plays, the filters keep their state so there's
+// no click. Guarded by the speaker lock.
+type equalizer struct {
+	Streamer beep.Streamer
+	preset   string
+	bands    []eqBand
+	filters  []audio.Biquad
+}
+
+// newEqualizer starts with the config's preset, flat if it hasn't got one
+func newEqualizer(streamer beep.Streamer) *equalizer {
+	e := &equalizer{Streamer: streamer}
+	name := cfg.EQ
+	if name == "" {
+		name = defaultEQPreset
+	}
+	bands, ok := eqPreset(name)
+	if !ok {
+		log.Printf("newEqualizer(): no EQ preset named %q, using %s", name, defaultEQPreset)
+		name = defaultEQPreset
+		bands, _ = eqPreset(name)
+	}
+	e.use(name, bands)
+	return e
+}
+
+func (e *equalizer) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = e.Streamer.Stream(samples)
+	for i := range samples[:n] {
+		for f := range e.filters {
+			samples[i] = e.filters[f].Process(samples[i])
+		}
+	}
+	return n, ok
+}
+
+func (e *equalizer) Err() error {
+	return e.Streamer.Err()
+}
+
+// use switches to bands, must be called with the speaker locked once it's
+// playing
+func (e *equalizer) use(preset string, bands []eqBand) {
+	if len(bands) != len(e.filters) {
+		e.filters = make([]audio.Biquad, len(bands))
+	}
+	for i, band := range bands {
+		e.filters[i].Set(band.coefficients(float64(SpeakerSampleRate)))
+	}
+	e.preset, e.bands = preset, bands
+}
+
+// current returns the preset in use and a copy of its bands
+func (e *equalizer) current() (string, []eqBand) {
+	speaker.Lock()
+	defer speaker.Unlock()
+	return e.preset, append([]eqBand(nil), e.bands...)
+}
+
+// setPreset switches to the named preset and makes it the default
+func (e *equalizer) setPreset(name string) error {
+	bands, ok := eqPreset(name)
+	if !ok {
+		return fmt.Errorf("equalizer.setPreset(): no preset named %q", name)
+	}
+	speaker.Lock()
+	e.use(name, bands)
+	speaker.Unlock()
+
+	cfg.EQ = name
+	return cfg.saveSoon(configFile)
+}
+
+// setGain changes band i's gain. A built in preset is copied to the custom
+// one first, user presets are changed in the config.
+func (e *equalizer) setGain(i int, gain float64) error {
+	preset, bands := e.current()
+	if i < 0 || i >= len(bands) {
+		return fmt.Errorf("equalizer.setGain(): no band %d", i)
+	}
+	if _, user := cfg.EQPresets[preset]; !user {
+		preset = customEQPreset
+	}
+	bands[i].Gain = clampBandGain(gain)
+
+	speaker.Lock()
+	e.use(preset, bands)
+	speaker.Unlock()
+
+	if cfg.EQPresets == nil {
+		cfg.EQPresets = make(map[string][]eqBand)
+	}
+	cfg.EQPresets[preset] = bands
+	cfg.EQ = preset
+	return cfg.saveSoon(configFile)
+}
+
+// slider draws gain as a bar centred on 0dB
+func slider(gain float64) string {
+	const half = 12
+	pos := half + int(math.Round(clampBandGain(gain)/maxBandGain*half))
+	bar := []rune(strings.Repeat("─", 2*half+1))
+	bar[half] = '┼'
+	bar[pos] = '●'
+	return string(bar)
+}
+
+// createEQList shows the equaliser's preset and a slider for each band.
+// Left and Right change the highlighted preset or band, the sound changes
+// straight away.
+func createEQList(eq *equalizer) *cview.List {
+	eqList := cview.NewList()
+
+	fill := func() {
+		index := eqList.GetCurrentItemIndex()
+		eqList.Clear()
+		preset, bands := eq.current()
+		li := cview.NewListItem("Preset: " + preset)
+		li.SetSecondaryText("←/→ to change")
+		eqList.AddItem(li)
+		for _, band := range bands {
+			li := cview.NewListItem(fmt.Sprintf("%-16s %s %+5.1fdB", band, slider(band.Gain), band.Gain))
+			q := band.Q
+			if q <= 0 {
+				q = defaultBandQ
+			}
+			li.SetSecondaryText(fmt.Sprintf("Q %.2f", q))
+			eqList.AddItem(li)
+		}
+		eqList.SetCurrentItem(min(index, len(bands)))
+	}
+	fill()
+
+	eqList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		var step int
+		switch {
+		case event.Modifiers() != tcell.ModNone:
+			return event
+		case event.Key() == tcell.KeyLeft:
+			step = -1
+		case event.Key() == tcell.KeyRight:
+			step = 1
+		default:
+			return event
+		}
+
+		var err error
+		preset, bands := eq.current()
+		if i := eqList.GetCurrentItemIndex(); i == 0 {
+			names := eqPresetNames()
+			at := 0
+			for j, name := range names {
+				if name == preset {
+					at = j
+				}
+			}
+			err = eq.setPreset(names[(at+step+len(names))%len(names)])
+		} else {
+			err = eq.setGain(i-1, bands[i-1].Gain+float64(step)*bandGainStep)
+		}
+		if err != nil {
+			log.Printf("createEQList(): %s", err)
+		}
+		fill()
+		return nil
+	})
+	return eqList
+}
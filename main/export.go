@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/jpeg"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lordxarus/ytmusic_cli/internal/art"
+	"github.com/lordxarus/ytmusic_cli/internal/atomicfile"
+	"github.com/lordxarus/ytmusic_cli/yt"
+	"github.com/lordxarus/ytmusic_cli/yt/cache"
+)
+
+// exportCodec is how to write a track for one export -codec. reisen only
+// binds libav's decoders so transcoding and tagging go through the ffmpeg
+// binary, the same libraries underneath. Without it there's no way to tag
+// the files so export refuses to run.
+type exportCodec struct {
+	// Empty keeps the extension of the cached download
+	ext  string
+	args []string
+}
+
+var exportCodecs = map[string]exportCodec{
+	"copy": {"", []string{"-c:a", "copy"}},
+	"opus": {".opus", []string{"-c:a", "libopus", "-b:a", "160k"}},
+	"mp3":  {".mp3", []string{"-c:a", "libmp3lame", "-q:a", "2"}},
+	"aac":  {".m4a", []string{"-c:a", "aac", "-b:a", "256k"}},
+	"flac": {".flac", []string{"-c:a", "flac"}},
+}
+
+// Containers ffmpeg can embed a cover in. Everything gets a cover.jpg in
+// the album directory as well.
+var coverContainers = map[string]bool{".mp3": true, ".m4a": true, ".flac": true}
+
+// exportRecord is what the manifest remembers about an exported track
+type exportRecord struct {
+	Path       string    `json:"path"`
+	Codec      string    `json:"codec"`
+	ExportedAt time.Time `json:"exportedAt"`
+}
+
+// exportManifest lives in the export directory, keyed by video ID, so
+// running the same export again only does what's left
+type exportManifest struct {
+	path   string
+	mu     sync.Mutex
+	Tracks map[string]exportRecord `json:"tracks"`
+}
+
+// exportJob is one track and where it's going
+type exportJob struct {
+	track yt.Track
+	// Relative to the export directory
+	dir  string
+	name string
+}
+
+type exporter struct {
+	ytm      *yt.YTMClient
+	index    *cache.Index
+	root     string
+	codec    string
+	ffmpeg   string
+	art      *art.Cache
+	manifest *exportManifest
+
+	// Serialises writing cover.jpg files
+	coverMu sync.Mutex
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dir := flags.String("dir", filepath.Join(homePath, "Music"), "directory to export to")
+	codec := flags.String("codec", "copy", "copy, opus, mp3, aac or flac")
+	jobs := flags.Int("jobs", 3, "how many tracks to download and convert at once")
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		usage()
+		return errors.New("export: expected album <browseId>, playlist <playlistId> or library")
+	}
+	if _, ok := exportCodecs[*codec]; !ok {
+		return fmt.Errorf("export: unknown codec %q", *codec)
+	}
+
+	client, err := session().newClient()
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	client.SetFormatPolicy(cfg.formatPolicy())
+	client.SetDownloaders(cfg.downloaders()...)
+	index, err := session().openCacheIndex()
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+
+	tracks, err := exportSelection(client, flags.Arg(0), flags.Args()[1:])
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	if len(tracks) == 0 {
+		fmt.Println("nothing to export")
+		return nil
+	}
+
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return errors.New("export: tagging and converting need ffmpeg on PATH")
+	}
+	artCache, err := art.NewCache(filepath.Join(cacheRoot, "art"))
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	if err = os.MkdirAll(*dir, 0o755); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	manifest, err := loadExportManifest(filepath.Join(*dir, ".ytmusic_cli-export.json"))
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+
+	e := &exporter{
+		ytm:      client,
+		index:    index,
+		root:     *dir,
+		codec:    *codec,
+		ffmpeg:   ffmpeg,
+		art:      artCache,
+		manifest: manifest,
+	}
+
+	var exported, skipped, failed atomic.Int32
+	sem := make(chan struct{}, max(*jobs, 1))
+	var wg sync.WaitGroup
+	for i, job := range exportJobs(tracks) {
+		prefix := fmt.Sprintf("[%d/%d] %s:", i+1, len(tracks), filepath.Join(job.dir, job.name))
+		if e.manifest.done(job.track.VideoId, e.root, e.codec) {
+			fmt.Println(prefix, "already exported, skipping")
+			skipped.Add(1)
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(job exportJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := e.export(job); err != nil {
+				fmt.Println(prefix, "failed:", err)
+				failed.Add(1)
+				return
+			}
+			fmt.Println(prefix, "done")
+			exported.Add(1)
+		}(job)
+	}
+	wg.Wait()
+
+	fmt.Printf("exported %d, skipped %d, failed %d to %s\n", exported.Load(), skipped.Load(), failed.Load(), *dir)
+	if failed.Load() > 0 {
+		return fmt.Errorf("export: %d track(s) failed, run it again to retry them", failed.Load())
+	}
+	return nil
+}
+
+// exportSelection resolves what's on the command line to tracks
+func exportSelection(client *yt.YTMClient, kind string, args []string) ([]yt.Track, error) {
+	switch kind {
+	case "album":
+		if len(args) != 1 {
+			return nil, errors.New("expected an album browse ID")
+		}
+		_, tracks, err := client.GetAlbum(args[0])
+		return tracks, err
+	case "playlist":
+		if len(args) != 1 {
+			return nil, errors.New("expected a playlist ID")
+		}
+		_, tracks, err := client.GetPlaylist(args[0])
+		if err != nil {
+			return nil, err
+		}
+		// Playlist tracks don't know their place on the album, number them
+		// by their place in the playlist instead
+		for i := range tracks {
+			if tracks[i].TrackNumber == 0 {
+				tracks[i].TrackNumber = i + 1
+			}
+		}
+		return tracks, nil
+	case "library":
+		return client.LibrarySongs(0)
+	}
+	return nil, fmt.Errorf("unknown selection %q, expected album, playlist or library", kind)
+}
+
+// exportJobs lays tracks out as Artist/Album/NN Title. Tracks that would
+// end up with the same name get a number after it, in the order given.
+func exportJobs(tracks []yt.Track) []exportJob {
+	jobs := make([]exportJob, 0, len(tracks))
+	// Lower case so they don't clash on case insensitive filesystems either
+	taken := make(map[string]bool)
+	for _, track := range tracks {
+		artist := yt.Names(track.Album.Artists)
+		if artist == "" && len(track.Artists) > 0 {
+			artist = track.Artists[0].Name
+		}
+		album := track.Album.Name
+		if album == "" {
+			album = "Unknown Album"
+		}
+		name := sanitizeFileName(track.Title)
+		if track.TrackNumber > 0 {
+			name = fmt.Sprintf("%02d %s", track.TrackNumber, name)
+		}
+		dir := filepath.Join(sanitizeFileName(firstOf(artist, "Unknown Artist")), sanitizeFileName(album))
+		unique := name
+		for n := 2; taken[strings.ToLower(filepath.Join(dir, unique))]; n++ {
+			unique = fmt.Sprintf("%s (%d)", name, n)
+		}
+		taken[strings.ToLower(filepath.Join(dir, unique))] = true
+		jobs = append(jobs, exportJob{
+			track: track,
+			dir:   dir,
+			name:  unique,
+		})
+	}
+	return jobs
+}
+
+func (e *exporter) export(job exportJob) error {
+	track := job.track
+	src, err := e.ytm.DownloadVideo(track.VideoId)
+	if err != nil && !errors.Is(err, yt.ErrAlreadyDownloaded) {
+		return err
+	}
+	if err = e.index.Add(track.VideoId); err != nil {
+		log.Printf("exporter.export(): %s", err)
+	}
+
+	dir := filepath.Join(e.root, job.dir)
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	cover := e.cover(dir, track)
+
+	ext := exportCodecs[e.codec].ext
+	if ext == "" {
+		ext = filepath.Ext(src)
+	}
+	dest := filepath.Join(dir, job.name+ext)
+	// Written next to dest and renamed so an interrupted export never
+	// leaves a half written file under the real name
+	tmp := filepath.Join(dir, ".export-"+track.VideoId+ext)
+	defer os.Remove(tmp)
+
+	if err = e.convert(src, cover, tmp, ext, track); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, dest); err != nil {
+		return err
+	}
+
+	rel, _ := filepath.Rel(e.root, dest)
+	previous, err := e.manifest.add(track.VideoId, exportRecord{Path: rel, Codec: e.codec, ExportedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	// Exported before with another codec, that file is replaced by this one
+	if previous.Path != "" && previous.Path != rel {
+		if err = os.Remove(filepath.Join(e.root, previous.Path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("exporter.export(): %s", err)
+		}
+	}
+	return nil
+}
+
+// convert runs ffmpeg to transcode src into dest and tag it
+func (e *exporter) convert(src string, cover string, dest string, ext string, track yt.Track) error {
+	args := []string{"-y", "-loglevel", "error", "-i", src}
+	embed := cover != "" && coverContainers[ext]
+	if embed {
+		args = append(args, "-i", cover, "-map", "0:a", "-map", "1:v", "-c:v", "copy", "-disposition:v", "attached_pic")
+	} else {
+		args = append(args, "-map", "0:a")
+	}
+	args = append(args, exportCodecs[e.codec].args...)
+	if ext == ".mp3" {
+		// Most players don't read ID3v2.4
+		args = append(args, "-id3v2_version", "3")
+	}
+
+	tags := map[string]string{
+		"title":        track.Title,
+		"artist":       yt.Names(track.Artists),
+		"album":        track.Album.Name,
+		"album_artist": yt.Names(track.Album.Artists),
+	}
+	if track.Year > 0 {
+		tags["date"] = fmt.Sprint(track.Year)
+	}
+	if track.TrackNumber > 0 {
+		tags["track"] = fmt.Sprint(track.TrackNumber)
+	}
+	for key, value := range tags {
+		if value != "" {
+			args = append(args, "-metadata", key+"="+value)
+		}
+	}
+	args = append(args, dest)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(e.ffmpeg, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// cover writes the track's art to dir/cover.jpg if it isn't there yet and
+// returns the path. Missing art isn't worth failing an export over.
+func (e *exporter) cover(dir string, track yt.Track) string {
+	path := filepath.Join(dir, "cover.jpg")
+
+	e.coverMu.Lock()
+	defer e.coverMu.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+
+	thumbnails := track.Album.Thumbnails
+	if len(thumbnails) == 0 {
+		thumbnails = track.Thumbnails
+	}
+	url := art.Pick(thumbnails, coverSize)
+	if url == "" {
+		return ""
+	}
+	img, err := e.art.Get(url)
+	if err != nil {
+		log.Printf("exporter.cover(): %s", err)
+		return ""
+	}
+
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		log.Printf("exporter.cover(): %s", err)
+		return ""
+	}
+	if err = os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		log.Printf("exporter.cover(): %s", err)
+		return ""
+	}
+	return path
+}
+
+func loadExportManifest(path string) (*exportManifest, error) {
+	manifest := &exportManifest{path: path, Tracks: make(map[string]exportRecord)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("loadExportManifest(): %w", err)
+	}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("loadExportManifest(): failed to decode %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// done is true if videoId was exported with codec and the file is still
+// there
+func (m *exportManifest) done(videoId string, root string, codec string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.Tracks[videoId]
+	if !ok || record.Codec != codec {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(root, record.Path))
+	return err == nil
+}
+
+// add records videoId and returns what was recorded for it before, if
+// anything
+func (m *exportManifest) add(videoId string, record exportRecord) (exportRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.Tracks[videoId]
+	m.Tracks[videoId] = record
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return previous, fmt.Errorf("exportManifest.add(): %w", err)
+	}
+	if err = atomicfile.WriteFile(m.path, data, 0o644); err != nil {
+		return previous, fmt.Errorf("exportManifest.add(): %w", err)
+	}
+	return previous, nil
+}
+
+// sanitizeFileName makes s safe to use as a single path element on any
+// filesystem we're likely to write to
+func sanitizeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.Trim(s, " .")
+	if len(s) > 200 {
+		s = s[:200]
+	}
+	if s == "" {
+		return "_"
+	}
+	return s
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// fifo needs named pipes, without them play() waits for the whole download
+func (d *download) fifo() (path string, cleanup func(), err error) {
+	return "", nil, errors.New("download.fifo(): named pipes aren't supported here")
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// fifo makes a named pipe for the decoder to read d from and starts feeding
+// it. cleanup removes it and must be called once the decoder has opened it,
+// or failed to.
+func (d *download) fifo() (path string, cleanup func(), err error) {
+	dir, err := os.MkdirTemp("", "ytmusic_cli-")
+	if err != nil {
+		return "", nil, fmt.Errorf("download.fifo(): %w", err)
+	}
+	path = filepath.Join(dir, d.videoId)
+	if err = syscall.Mkfifo(path, 0o600); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("download.fifo(): %w", err)
+	}
+
+	go func() {
+		// Blocks until the decoder opens the other end
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			log.Printf("download.fifo(): %s", err)
+			return
+		}
+		defer w.Close()
+		// Stops with a broken pipe if the decoder goes away first
+		if err = d.stream(w); err != nil {
+			log.Printf("download.fifo(): %s", err)
+		}
+	}()
+
+	cleanup = func() {
+		// Unblock the writer in case the decoder never opened the fifo
+		if r, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+			r.Close()
+		}
+		os.RemoveAll(dir)
+	}
+	return path, cleanup, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lordxarus/ytmusic_cli/internal/audio"
+	"github.com/lordxarus/ytmusic_cli/yt"
+	"github.com/lordxarus/ytmusic_cli/yt/cache"
+	"github.com/zergon321/reisen"
+)
+
+// Normalised tracks play at ReplayGain 2's reference level, in LUFS
+const referenceLoudness = -18.0
+
+// The gain is held down so the true peak stays under this, in dBTP
+const peakCeiling = -1.0
+
+// How normalisation works out a track's gain
+const (
+	normalizeOff   = "off"
+	normalizeTrack = "track"
+	normalizeAlbum = "album"
+)
+
+// How many tracks can wait to be analysed, more are picked up next time
+// they're asked about
+const analysisBacklog = 32
+
+// A track that still isn't downloaded this long after it was asked about
+// likely won't be. It's asked about again if it's played.
+const wantedFor = 30 * time.Minute
+
+// normalizer works out the gain each track plays at. Tracks are analysed
+// one at a time in the background once they're downloaded, until then
+// they play as they are.
+type normalizer struct {
+	mu sync.Mutex
+	// Tracks asked about that haven't been analysed, so they can be once
+	// they're downloaded
+	wanted map[string]wantedTrack
+	queued map[string]bool
+	work   chan yt.Track
+	// Analyses of albumsDir's downloads by album and video ID for album
+	// mode, read from the sidecars the first time they're needed. Tracks
+	// evicted since stay in, their analysis still counts for the album.
+	albumsDir string
+	albums    map[string]map[string]cache.Loudness
+}
+
+type wantedTrack struct {
+	track yt.Track
+	since time.Time
+}
+
+func newNormalizer() *normalizer {
+	n := &normalizer{
+		wanted: make(map[string]wantedTrack),
+		queued: make(map[string]bool),
+		work:   make(chan yt.Track, analysisBacklog),
+	}
+	go n.run()
+	return n
+}
+
+// want has track analysed if it hasn't been, as soon as it's downloaded
+func (n *normalizer) want(track yt.Track) {
+	if cfg.normalize() == normalizeOff {
+		return
+	}
+	if _, ok := cache.LoadLoudness(session().cachePath, track.VideoId); ok {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	for videoId, w := range n.wanted {
+		if now.Sub(w.since) > wantedFor && !n.queued[videoId] {
+			delete(n.wanted, videoId)
+		}
+	}
+	if _, ok := n.wanted[track.VideoId]; !ok {
+		n.wanted[track.VideoId] = wantedTrack{track: track, since: now}
+	}
+	if n.queued[track.VideoId] {
+		return
+	}
+	if _, cached := session().ytm.CachedPath(track.VideoId); !cached {
+		return
+	}
+	select {
+	case n.work <- track:
+		n.queued[track.VideoId] = true
+	default:
+	}
+}
+
+// downloaded analyses videoId if it was wanted before it was downloaded
+func (n *normalizer) downloaded(videoId string) {
+	n.mu.Lock()
+	w, ok := n.wanted[videoId]
+	n.mu.Unlock()
+	if ok {
+		n.want(w.track)
+	}
+}
+
+func (n *normalizer) run() {
+	for track := range n.work {
+		s := session()
+		analysis, err := analyze(s, track)
+		if err != nil {
+			log.Printf("normalizer.run(): %s", err)
+		}
+		n.mu.Lock()
+		delete(n.queued, track.VideoId)
+		delete(n.wanted, track.VideoId)
+		if err == nil && analysis.AlbumId != "" && n.albums != nil && n.albumsDir == s.cachePath {
+			n.addAlbumTrack(analysis)
+		}
+		n.mu.Unlock()
+	}
+}
+
+// gain is what to scale track by, 1 if it hasn't been analysed yet or
+// normalisation is off
+func (n *normalizer) gain(track yt.Track) float64 {
+	mode := cfg.normalize()
+	if mode == normalizeOff {
+		return 1
+	}
+	analysis, ok := cache.LoadLoudness(session().cachePath, track.VideoId)
+	if !ok {
+		n.want(track)
+		return 1
+	}
+
+	integrated, peak := analysis.Integrated, analysis.TruePeak
+	if mode == normalizeAlbum && track.Album.ID != "" {
+		if albumIntegrated, albumPeak, ok := n.albumLoudness(track.Album.ID); ok {
+			integrated, peak = albumIntegrated, albumPeak
+		}
+	}
+
+	db := referenceLoudness - integrated + cfg.Preamp
+	if limit := peakCeiling - peak; db > limit {
+		log.Printf("normalizer.gain(): holding %s at %+.1fdB rather than %+.1fdB so it doesn't clip", track.VideoId, limit, db)
+		db = limit
+	}
+	log.Printf("normalizer.gain(): %s at %.1f LUFS, %+.1fdB in %s mode", track.VideoId, integrated, db, mode)
+	return math.Pow(10, db/20)
+}
+
+// albumLoudness is the loudness and true peak of every downloaded track
+// from albumId that's been analysed, taken together
+func (n *normalizer) albumLoudness(albumId string) (float64, float64, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if dir := session().cachePath; n.albums == nil || n.albumsDir != dir {
+		albums, err := cache.AlbumLoudness(dir)
+		if err != nil {
+			log.Printf("normalizer.albumLoudness(): %s", err)
+			return 0, 0, false
+		}
+		n.albumsDir, n.albums = dir, make(map[string]map[string]cache.Loudness, len(albums))
+		for _, tracks := range albums {
+			for _, t := range tracks {
+				n.addAlbumTrack(t)
+			}
+		}
+	}
+
+	tracks := n.albums[albumId]
+	peak := audio.Silence
+	blocks := make([]audio.Blocks, 0, len(tracks))
+	for _, t := range tracks {
+		peak = max(peak, t.TruePeak)
+		blocks = append(blocks, t.Blocks)
+	}
+	integrated, ok := audio.Integrated(blocks...)
+	return integrated, peak, ok
+}
+
+// addAlbumTrack puts t in the album index. Called with mu held.
+func (n *normalizer) addAlbumTrack(t cache.Loudness) {
+	album := n.albums[t.AlbumId]
+	if album == nil {
+		album = make(map[string]cache.Loudness)
+		n.albums[t.AlbumId] = album
+	}
+	album[t.VideoId] = t
+}
+
+// analyze decodes all of track's download from s's cache and writes its
+// loudness beside it
+func analyze(s *profileSession, track yt.Track) (cache.Loudness, error) {
+	path, cached := s.ytm.CachedPath(track.VideoId)
+	if !cached {
+		return cache.Loudness{}, fmt.Errorf("analyze(): %s isn't downloaded", track.VideoId)
+	}
+	media, err := reisen.NewMedia(path)
+	if err != nil {
+		return cache.Loudness{}, fmt.Errorf("analyze(): %w", err)
+	}
+	defer media.Close()
+	stream, err := openAudio(media)
+	if err != nil {
+		return cache.Loudness{}, fmt.Errorf("analyze(): %w", err)
+	}
+	defer media.CloseDecode()
+	defer stream.Close()
+
+	meter := audio.NewMeter(stream.SampleRate())
+	var block [][2]float64
+	for {
+		packet, gotPacket, err := media.ReadPacket()
+		if err != nil {
+			return cache.Loudness{}, fmt.Errorf("analyze(): failed to read %s: %w", track.VideoId, err)
+		}
+		if !gotPacket {
+			break
+		}
+		if packet.StreamIndex() != stream.Index() {
+			continue
+		}
+		frame, gotFrame, err := stream.ReadAudioFrame()
+		if err != nil {
+			return cache.Loudness{}, fmt.Errorf("analyze(): failed to decode %s: %w", track.VideoId, err)
+		}
+		if !gotFrame {
+			break
+		}
+		if frame == nil {
+			continue
+		}
+		block = audio.AppendStereo(block[:0], frame.Data())
+		meter.Add(block)
+	}
+
+	integrated, ok := meter.Integrated()
+	if !ok {
+		return cache.Loudness{}, fmt.Errorf("analyze(): %s is silent", track.VideoId)
+	}
+	analysis := cache.Loudness{
+		VideoId:    track.VideoId,
+		AlbumId:    track.Album.ID,
+		Integrated: integrated,
+		TruePeak:   meter.TruePeak(),
+		Blocks:     meter.Blocks(),
+	}
+	if err = cache.SaveLoudness(filepath.Dir(path), analysis); err != nil {
+		return cache.Loudness{}, fmt.Errorf("analyze(): %w", err)
+	}
+	log.Printf("analyze(): %s is %.1f LUFS, peaks at %.1f dBTP", track.VideoId, analysis.Integrated, analysis.TruePeak)
+	return analysis, nil
+}
+
+// parseNormalize checks the normalize setting from the config
+func parseNormalize(mode string) (string, error) {
+	switch mode = strings.ToLower(mode); mode {
+	case "", normalizeOff:
+		return normalizeOff, nil
+	case normalizeTrack, normalizeAlbum:
+		return mode, nil
+	}
+	return normalizeOff, fmt.Errorf("unknown normalize mode %q, expected off, track or album", mode)
+}
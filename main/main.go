@@ -1,9 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
-	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -11,7 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
 
 	"code.rocketnine.space/tslocum/cview"
@@ -20,24 +18,33 @@ import (
 	"github.com/faiface/beep/speaker"
 	"github.com/gdamore/tcell/v2"
 	"github.com/joho/godotenv"
+	"github.com/lordxarus/ytmusic_cli/internal/art"
 	"github.com/lordxarus/ytmusic_cli/yt"
+	"github.com/lordxarus/ytmusic_cli/yt/queue"
 	"github.com/lordxarus/ytmusic_cli/yt/search"
-	"github.com/zergon321/reisen"
 )
 
 var (
 	// Keep paths clean with no trailing slash
 	// TODO Could use filepath.Clean()
 	homePath   string
-	cachePath  string
+	cacheRoot  string
+	stateRoot  string
+	configPath string
+	configFile string
 	wdPath     string
 	app        = cview.NewApplication()
 	oauthToken string
-	brandId    string
+	envBrandId string
+	cfg        *config
 	logging    = false
-	ytm        *yt.YTMClient
+	loudness   *normalizer
+	playQueue  = queue.New()
+	nowPlaying atomic.Pointer[playing]
+	// Opened ahead of time to follow nowPlaying without a gap
+	upNext atomic.Pointer[playing]
+
 	// TODO Not sure if I want these here
-	decoderKeepAlive  = make(chan bool, 1)
 	progressBarRunner *tickerBar
 )
 
@@ -50,52 +57,73 @@ const (
 	gKillDecoderBufferSize                 = 50
 )
 
+// playing is whatever the speaker is currently playing
+type playing struct {
+	*playback
+	song yt.Track
+	// Only set when the track is a podcast episode
+	episode *yt.Episode
+	item    *cview.ListItem
+	// The queue entry it was played as
+	entryId int
+}
+
 func init() {
 	var ok bool
 	var err error
 
+	// .env is optional, `ytmusic_cli auth` saves credentials under configPath.
+	// newClient() complains if neither is there.
 	if err := godotenv.Load(); err != nil {
-		log.Fatal("set your oauth token through a .env file")
-	} else {
-		oauthToken = os.Getenv("OAUTH_TOKEN")
-		brandId = os.Getenv("BRAND_ID")
-		logVar := os.Getenv("LOGGING")
-
-		switch {
-		case oauthToken == "":
-			log.Fatalf("no field OAUTH_TOKEN in .env")
-		case brandId == "":
-			log.Println("no brand ID in .env using default")
-		case logVar != "":
-			logging, err = strconv.ParseBool(logVar)
-			if err != nil && !logging {
-				log.Println("disabling logging")
-				log.SetOutput(io.Discard)
-			}
+		log.Println("init(): no .env file")
+	}
+	oauthToken = os.Getenv("OAUTH_TOKEN")
+	envBrandId = os.Getenv("BRAND_ID")
+	logVar := os.Getenv("LOGGING")
+
+	switch {
+	case envBrandId == "":
+		log.Println("no brand ID in .env using default")
+	case logVar != "":
+		logging, err = strconv.ParseBool(logVar)
+		if err != nil && !logging {
+			log.Println("disabling logging")
+			log.SetOutput(io.Discard)
 		}
-
 	}
 
 	if homePath, ok = os.LookupEnv("HOME"); !ok {
 		log.Fatalf("init() couldn't find home directory")
 	}
 
-	cachePath = homePath + "/.cache/ytmusic_cli"
+	cacheRoot = homePath + "/.cache/ytmusic_cli"
+	stateRoot = homePath + "/.local/state/ytmusic_cli"
+	configPath = homePath + "/.config/ytmusic_cli"
+	configFile = filepath.Join(configPath, "config.json")
 
 	if wdPath, err = os.Getwd(); err != nil {
 		log.Fatalf("init() couldn't find working directory: %s", err)
 	}
 
-	log.Printf("init(): home: %s cache: %s wd: %s", homePath, cachePath, wdPath)
+	log.Printf("init(): home: %s cache: %s state: %s config: %s wd: %s", homePath, cacheRoot, stateRoot, configPath, wdPath)
 
-	if err = os.MkdirAll(cachePath, 0o750); err != nil {
+	if err = os.MkdirAll(cacheRoot, 0o750); err != nil {
 		log.Fatalf("init() couldn't create cache directory: %s", err)
 	}
+	if err = os.MkdirAll(stateRoot, 0o750); err != nil {
+		log.Fatalf("init() couldn't create state directory: %s", err)
+	}
+	// Credentials live in here
+	if err = os.MkdirAll(configPath, 0o700); err != nil {
+		log.Fatalf("init() couldn't create config directory: %s", err)
+	}
+	if cfg, err = loadConfig(configFile); err != nil {
+		log.Fatalf("init() %s", err)
+	}
 	log.Println("successfully started ytmusic_cli")
 }
 
 // TODO We can rewrite this to only use beep
-// TODO Beep supports playing and pausing
 // TODO I should probably create my own types for use in my own internal stuff.
 // I currently have the Song result that you get from the search end point implemented.
 // But when I go to, for example, implement the Song result type that you would get from
@@ -103,7 +131,6 @@ func init() {
 // similarly to ytmusicapi. Based on which endpoint they come from. yt.search.Song and yt.home.Song or something.
 // And then I can have my own Song type for my GUI which will include only what I need.
 // TODO Use ffplay instead of beep
-// TODO Handling of a song's completion
 func main() {
 	// DON'T REMOVE THIS.
 	// If you use the form: value, ok := something() and
@@ -112,6 +139,25 @@ func main() {
 	// assigned elsewhere.
 	var err error
 
+	profileFlag := flag.String("profile", cfg.DefaultProfile, "profile from config.json to use")
+	flag.Usage = usage
+	flag.Parse()
+
+	if err = selectProfile(*profileFlag); err != nil {
+		log.Fatalf("main(): %s", err)
+	}
+
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] == "help" {
+			usage()
+			return
+		}
+		if err = runCommand(args[0], args[1:]); err != nil {
+			log.Fatalf("main(): %s", err)
+		}
+		return
+	}
+
 	// Flex boxes
 	var rootFlex *cview.Flex
 	var mainFlex *cview.Flex
@@ -127,27 +173,49 @@ func main() {
 	pauseLabel := "󰏥"
 	playLabel := ""
 
+	// Previous and next track buttons
+	var previousButton *cview.Button
+	var nextButton *cview.Button
+
 	// Song list
 	var songList *cview.List
-	var songResults []yt.Song
+	var songResults []yt.Track
+
+	// Album art for whatever's playing or being browsed
+	var cover *coverView
 
 	// Progress bar
 	var progressBar *cview.ProgressBar
 
+	var frame *cview.Frame
+
 	// Volume bar
 	var volumeBar *cview.ProgressBar
+	// Equaliser, between the deck and the volume
+	eq := newEqualizer(speakerDeck)
 	var volumeEffect *effects.Volume = &effects.Volume{
-		Streamer: nil,
+		Streamer: eq,
 		Base:     2,
 		Volume:   -1.0,
 		Silent:   false,
 	}
 
-	// Create YTM client
-	ytm, err = yt.New(oauthToken, brandId, cachePath)
-	if err != nil {
-		log.Fatalf("main() failed to create ytm client: %s", err)
+	// Analyses tracks for loudness normalisation in the background
+	loudness = newNormalizer()
+
+	// Create YTM client, history outbox and episode store
+	if err = openProfile(); err != nil {
+		log.Fatalf("main(): %s", err)
 	}
+	defer closeProfile()
+
+	// Keep episode positions fresh in case we don't get to exit cleanly
+	go func() {
+		for range time.Tick(time.Second * 10) {
+			saveEpisodePosition()
+		}
+	}()
+
 	// Init speaker
 	err = speaker.Init(sampleRate, SpeakerSampleRate.N(time.Second/10))
 	if err != nil {
@@ -156,48 +224,247 @@ func main() {
 
 	query := "Best Classical Music"
 
-	songResults, err = ytm.Search(query, search.Songs)
+	songResults, err = session().ytm.Search(query, search.Songs)
 	if err != nil {
 		log.Fatalf("main() initial query failed: %s", err)
 	}
 
-	err = ytm.DownloadVideo(songResults[0].VideoId)
-	if err != nil && !errors.Is(err, yt.ErrAlreadyDownloaded) {
-		log.Fatalf("main() failed to download video: %s", err)
+	// Get the first result ready in the background
+	session().prefetcher.Set([]string{songResults[0].VideoId})
+
+	// Called when a track plays to the end, next is what the deck carried
+	// on into if it did
+	var trackEnded func(current *playing, next *playback)
+
+	// Shows current, which has just started, as playing
+	startedPlaying := func(current *playing) {
+		holdPlaying()
+		if err := session().outbox.Add(current.song.VideoId); err != nil {
+			log.Printf("startedPlaying(): %s", err)
+		}
+		cover.show(current.song.Title, current.song.Thumbnails)
+		// The container knows better, uploads and episodes often come
+		// without a duration
+		length := func() time.Duration {
+			if d, ok := current.playback.length(); ok {
+				return d
+			}
+			return current.song.Duration
+		}
+		progressBarRunner.start(length, current.position.Position)
+		playButton.SetLabel(pauseLabel)
+		app.Draw(controlsFlex)
 	}
 
-	// Called when a song is selected on the songList or when play is pressed
-	playSong := func() {
+	// Plays entry, which the queue has already moved to. item is the list
+	// item it was picked from, if it was.
+	var playEntry func(entry queue.Entry, item *cview.ListItem)
+	playEntry = func(entry queue.Entry, item *cview.ListItem) {
 		progressBarRunner.stop()
-		// *selectedSong = song
-		song, ok := songList.GetCurrentItem().GetReference().(yt.Song)
-		if !ok {
-			log.Printf("playSong(): no song selected, skipping")
-			return
+		saveEpisodePosition()
+
+		song := entry.Track
+		episode := entry.Episode
+		var from time.Duration
+		if episode != nil {
+			from = session().episodes.resumeAt(episode.VideoId)
 		}
+		// song stays in the list so a prefetch of it carries on until play()
+		// has taken over its download
+		session().prefetcher.Played(song.VideoId)
+		session().prefetcher.Set(append([]string{song.VideoId}, upcoming()...))
 
 		// debug logging
 		now := time.Now()
-		done := now.Add(time.Second * time.Duration(song.Duration_Seconds))
+		done := now.Add(song.Duration)
 		log.Printf("playButton: starting at: %s. expected song end: %s",
 			now.Format(time.Stamp), done.Format(time.Stamp))
 
 		// play song
-		go func(callback func()) {
-			err = play(song, volumeEffect)
-			if err != nil {
-				log.Fatalf("playSong(): failed to play: %s", err)
+		go func() {
+			current := &playing{
+				song:    song,
+				episode: episode,
+				item:    item,
+				entryId: entry.ID,
 			}
-			err = ytm.AddToHistory(song.VideoId)
+			p, err := play(song, from, volumeEffect, func(next *playback) { trackEnded(current, next) })
 			if err != nil {
-				log.Printf("playSong(): routine: %s", err)
+				log.Fatalf("playEntry(): failed to play: %s", err)
+			}
+			current.playback = p
+			nowPlaying.Store(current)
+			startedPlaying(current)
+		}()
+	}
+
+	// Opens what the queue plays after current for the deck to go straight
+	// into. Anything that isn't downloaded yet is left to trackEnded().
+	speakerDeck.crossfade = cfg.crossfade()
+	speakerDeck.nearEnd = func(current *playback) {
+		entry, ok := playQueue.Following()
+		if !ok {
+			return
+		}
+		path, cached := session().ytm.CachedPath(entry.Track.VideoId)
+		if !cached {
+			return
+		}
+		var from time.Duration
+		if entry.Episode != nil {
+			from = session().episodes.resumeAt(entry.Episode.VideoId)
+		}
+		streamer, err := loadAudio(path, from, false)
+		if err != nil {
+			log.Printf("nearEnd(): couldn't open %s: %s", entry.Track.VideoId, err)
+			return
+		}
+
+		next := &playing{
+			song:    entry.Track,
+			episode: entry.Episode,
+			entryId: entry.ID,
+		}
+		next.playback = newPlayback(streamer, loudness.gain(entry.Track), func(after *playback) { trackEnded(next, after) })
+		if old := upNext.Swap(next); old != nil {
+			old.stop()
+		}
+		holdPlaying()
+		// Crossfading would spoil an album that's meant to carry straight on
+		fade := true
+		if p := nowPlaying.Load(); p != nil && p.playback == current {
+			fade = !contiguous(p.song, entry.Track)
+		}
+		if !speakerDeck.setNext(current, next.playback, fade) {
+			if upNext.CompareAndSwap(next, nil) {
+				next.stop()
 			}
-			callback()
-		}(func() {
-			progressBarRunner.start(time.Second * time.Duration(song.Duration_Seconds))
-			playButton.SetLabel(pauseLabel)
-			app.Draw(controlsFlex)
+			return
+		}
+		log.Printf("nearEnd(): %s is up next, ID: %s", entry.Track.Title, entry.Track.VideoId)
+	}
+
+	// Records current as played to the end
+	markFinished := func(current *playing) {
+		if err := session().plays.finished(current.song.VideoId); err != nil {
+			log.Printf("markFinished(): %s", err)
+		}
+		if current.episode == nil {
+			return
+		}
+		if err := session().episodes.setPlayed(current.episode.VideoId, true); err != nil {
+			log.Printf("markFinished(): %s", err)
+		}
+		if current.item != nil {
+			episode := *current.episode
+			app.QueueUpdateDraw(func() {
+				current.item.SetMainText(episodeLabel(episode))
+			})
+		}
+	}
+
+	trackEnded = func(current *playing, next *playback) {
+		var following *playing
+		if next != nil {
+			following = upNext.Swap(nil)
+			if following == nil || following.playback != next {
+				// Stopped while the deck was going over to it
+				next.stop()
+				if following != nil {
+					following.stop()
+				}
+				following = nil
+			}
+		}
+
+		// Something else may have been picked in the meantime
+		if !nowPlaying.CompareAndSwap(current, following) {
+			if following != nil {
+				following.stop()
+			}
+			return
+		}
+		// A decoding error ends the track early, it doesn't count as played
+		if err := current.playback.err(); err != nil {
+			log.Printf("trackEnded(): %s ended early, ID: %s: %s", current.song.Title, current.song.VideoId, err)
+		} else {
+			log.Printf("trackEnded(): finished %s, ID: %s", current.song.Title, current.song.VideoId)
+			markFinished(current)
+		}
+		current.stop()
+
+		progressBarRunner.stop()
+		progressBarRunner.setStatus(nil)
+		if following != nil {
+			// Already playing, the queue and everything else catch up
+			if entry, ok := playQueue.Finished(); !ok || entry.ID != following.entryId {
+				log.Printf("trackEnded(): queue moved on to something other than %s", following.song.VideoId)
+			}
+			if err := session().cacheIndex.Played(following.song.VideoId); err != nil {
+				log.Printf("trackEnded(): %s", err)
+			}
+			session().prefetcher.Played(following.song.VideoId)
+			session().prefetcher.Set(upcoming())
+			startedPlaying(following)
+			return
+		}
+
+		app.QueueUpdateDraw(func() {
+			progressBar.SetProgress(0)
+			playButton.SetLabel(playLabel)
 		})
+
+		if next, ok := playQueue.Finished(); ok {
+			playEntry(next, nil)
+		}
+	}
+
+	// Called when a song is selected on the songList or when play is pressed.
+	// The list becomes the queue, starting from the selected song.
+	playSong := func() {
+		item := songList.GetCurrentItem()
+		if item == nil {
+			log.Printf("playSong(): no song selected, skipping")
+			return
+		}
+		if _, ok := item.GetReference().(queue.Entry); ok {
+			// The queue view is showing, play from where it's at
+			if entry, ok := playQueue.Jump(songList.GetCurrentItemIndex()); ok {
+				playEntry(entry, nil)
+			}
+			return
+		}
+
+		entries, index := listEntries(songList)
+		if index < 0 {
+			log.Printf("playSong(): selected item isn't playable, skipping")
+			return
+		}
+		entry, _ := playQueue.Set(entries, index)
+		playEntry(entry, item)
+	}
+
+	// Skips forwards or backwards through the queue
+	skip := func(forward bool) {
+		var entry queue.Entry
+		var ok bool
+		if forward {
+			entry, ok = playQueue.Next()
+		} else {
+			entry, ok = playQueue.Previous()
+		}
+		if ok {
+			playEntry(entry, nil)
+		}
+	}
+
+	// Skips within whatever is playing
+	seekBy := func(delta time.Duration) {
+		if p := nowPlaying.Load(); p != nil {
+			if err := p.seekBy(delta); err != nil {
+				log.Printf("seek failed: %s", err)
+			}
+		}
 	}
 
 	purple := tcell.Color98
@@ -218,7 +485,6 @@ func main() {
 	// Build CUI
 
 	// Play button
-	// Can use VolumeEffect.Silent to play/pause
 	playButton = cview.NewButton(playLabel)
 	playButton.SetCursorRune(0)
 	playButton.SetPadding(0, 1, 0, 0)
@@ -228,30 +494,141 @@ func main() {
 	playButton.SetBackgroundColorFocused(pink)
 	playButton.SetSelectedFunc(
 		func() {
+			// Pausing leaves the decoder where it is, muting is the volume
+			// bar's business
+			p := nowPlaying.Load()
 			switch playButton.GetLabel() {
 			// We are paused
 			case playLabel:
-				playSong()
+				if p != nil && p.paused() {
+					p.setPaused(false)
+					playButton.SetLabel(pauseLabel)
+				} else {
+					playSong()
+				}
 			// We are playing
 			case pauseLabel:
-				progressBarRunner.stop()
-				decoderKeepAlive <- true
-				// TODO Maybe VolumeEffect.Silent
-				// should be the play/pause state. Because if a user mutes the volume
-				// it will as of now pause anyway which isn't really the behavior I want
-				// out of a mute button. Might as well just use VolumeEffect.Silent instead of playButton.GetLabel()
-				// Remove the middle mouse handler code and add it here instead
-				// need to read up on concurrency in go more
-				// Although as I think about it, pausing on mute isn't a bad feature at all
-				// https://golang.org/doc/effective_go.html#concurrency
-				// volumeEffect.Silent = true
+				if p != nil {
+					p.setPaused(true)
+					saveEpisodePosition()
+				}
 				playButton.SetLabel(playLabel)
 			}
 		})
 
+	// Previous and next buttons, styled like the play button
+	controlButton := func(label string, selectedFunc func()) *cview.Button {
+		button := cview.NewButton(label)
+		button.SetCursorRune(0)
+		button.SetLabelColor(tcell.ColorAntiqueWhite)
+		button.SetLabelColorFocused(tcell.ColorAntiqueWhite)
+		button.SetBackgroundColor(purple)
+		button.SetBackgroundColorFocused(pink)
+		button.SetSelectedFunc(selectedFunc)
+		return button
+	}
+	previousButton = controlButton("󰒮", func() { skip(false) })
+	nextButton = controlButton("󰒭", func() { skip(true) })
+
 	// Song list
 	songList = createSongList(songResults, playSong)
 
+	// Swaps out whatever list is in mainFlex
+	showList := func(newList *cview.List) {
+		app.Lock()
+		mainFlex.RemoveItem(songList)
+		mainFlex.RemoveItem(cover)
+		mainFlex.AddItem(newList, 0, 3, false)
+		if cover.enabled() {
+			mainFlex.AddItem(cover, 0, 1, false)
+		}
+		songList = newList
+		app.Unlock()
+		app.Draw()
+	}
+
+	// Albums
+	openAlbum := func(album yt.Album) {
+		go func() {
+			album, tracks, err := session().ytm.GetAlbum(album.ID)
+			if err != nil {
+				log.Printf("failed to open album: %s", err)
+				return
+			}
+			cover.show(album.Name, album.Thumbnails)
+			showList(createSongList(tracks, playSong))
+		}()
+	}
+
+	// Podcasts
+	openPodcast := func(podcast yt.Podcast) {
+		go func() {
+			podcast, err := session().ytm.GetPodcast(podcast.BrowseId)
+			if err != nil {
+				log.Printf("openPodcast(): %s", err)
+				return
+			}
+			showList(createEpisodeList(podcast.Episodes, playSong))
+		}()
+	}
+	showingSaved := false
+
+	// Queue
+	var queueList *cview.List
+	openQueue := func() {
+		index := -1
+		if songList == queueList {
+			// Refreshing, keep the cursor where it was
+			index = songList.GetCurrentItemIndex()
+		}
+		queueList = createQueueList(playQueue, func(i int) {
+			if entry, ok := playQueue.Jump(i); ok {
+				playEntry(entry, nil)
+			}
+		})
+		if index >= 0 {
+			queueList.SetCurrentItem(index)
+		}
+		showList(queueList)
+	}
+	// Closes what was opened to follow the current track if the queue
+	// no longer plays it next
+	dropUpNext := func() {
+		up := upNext.Load()
+		if up == nil {
+			return
+		}
+		if entry, ok := playQueue.Following(); ok && entry.ID == up.entryId {
+			return
+		}
+		if speakerDeck.dropNext(up.playback) && upNext.CompareAndSwap(up, nil) {
+			up.stop()
+		}
+	}
+
+	playQueue.Subscribe(func(event queue.Event) {
+		switch event.Kind {
+		case queue.EntriesChanged:
+			// Edits while a track plays change what's coming up, a newly
+			// picked list is seen to by playEntry()
+			if p := nowPlaying.Load(); p != nil {
+				if current, _, ok := playQueue.Current(); ok && current.Track.VideoId == p.song.VideoId {
+					session().prefetcher.Set(upcoming())
+				}
+			}
+			dropUpNext()
+		case queue.ModeChanged:
+			dropUpNext()
+			app.QueueUpdateDraw(func() {
+				frame.Clear()
+				addFrameText(frame)
+			})
+		}
+		if songList == queueList {
+			go openQueue()
+		}
+	})
+
 	// Search field
 	searchField = cview.NewInputField()
 	searchField.SetLabel("Search: ")
@@ -259,18 +636,11 @@ func main() {
 	searchField.SetFieldTextColor(tcell.ColorBlack)
 	searchField.SetDoneFunc(func(key tcell.Key) {
 		if key == tcell.KeyEnter {
-			query, err := ytm.Search(searchField.GetText(), search.Songs)
+			query, err := session().ytm.Search(searchField.GetText(), search.Songs)
 			if err != nil {
 				log.Fatalf("failed to search: %s", err)
 			}
-			newList := createSongList(query, playSong)
-			app.Lock()
-			mainFlex.RemoveItem(songList)
-			mainFlex.AddItem(newList, 0, 6, false)
-			songList = newList
-			app.Unlock()
-			app.Draw()
-
+			showList(createSongList(query, playSong))
 		}
 	})
 
@@ -320,12 +690,14 @@ func main() {
 					*vol -= doStep
 				}
 			case cview.MouseMiddleClick:
+				// Mute, playback carries on silently
+				speaker.Lock()
+				volumeEffect.Silent = !volumeEffect.Silent
+				speaker.Unlock()
 				if volumeEffect.Silent {
-					volumeEffect.Silent = false
-					volumeBar.SetTitleColor(tcell.ColorDefault)
-				} else {
-					volumeEffect.Silent = true
 					volumeBar.SetTitleColor(tcell.ColorGray)
+				} else {
+					volumeBar.SetTitleColor(tcell.ColorDefault)
 				}
 
 			}
@@ -350,19 +722,54 @@ func main() {
 
 	progressBarRunner = newTickerBar(app, progressBar)
 
+	// Click to seek
+	progressBar.SetMouseCapture(func(action cview.MouseAction, event *tcell.EventMouse) (cview.MouseAction, *tcell.EventMouse) {
+		p := nowPlaying.Load()
+		if action != cview.MouseLeftClick || p == nil {
+			return action, event
+		}
+		x, _, width, _ := progressBar.GetInnerRect()
+		clickX, _ := event.Position()
+		if width <= 0 || clickX < x || clickX >= x+width {
+			return action, event
+		}
+		// The song's duration is often missing, the container knows better
+		length, known := p.length()
+		if !known {
+			return action, event
+		}
+		to := time.Duration(float64(length) * float64(clickX-x) / float64(width))
+		if err := p.seek(to); err != nil {
+			log.Printf("seek failed: %s", err)
+		}
+		return action, event
+	})
+
 	// // TODO songFlex is probably better named "contentFlex"
 	// or it will be when I have other things to populate it with
 	// I don't know how the page system works in cview though
+	artCache, err := art.NewCache(filepath.Join(cacheRoot, "art"))
+	if err != nil {
+		log.Fatalf("main(): %s", err)
+	}
+	cover = newCoverView(coverProtocol(), artCache)
+	app.SetAfterDrawFunc(cover.afterDraw)
+
 	mainFlex = cview.NewFlex()
 	mainFlex.SetBorder(true)
 	mainFlex.AddItem(songList, 0, 3, false)
+	if cover.enabled() {
+		mainFlex.AddItem(cover, 0, 1, false)
+	}
 
 	navFlex = cview.NewFlex()
 	navFlex.AddItem(searchField, 0, 1, true)
 
 	controlsFlex = cview.NewFlex()
 	// This fixedSize number is either rows or colums based on the direction of the flex, default is cols
+	controlsFlex.AddItem(previousButton, 0, 1, false)
 	controlsFlex.AddItem(playButton, 0, 1, false)
+	controlsFlex.AddItem(nextButton, 0, 1, false)
 	controlsFlex.AddItem(progressBar, 0, 5, false)
 	controlsFlex.AddItem(volumeBar, 0, 2, false)
 	controlsFlex.SetBorder(true)
@@ -378,6 +785,175 @@ func main() {
 		switch event.Key() {
 		case tcell.KeyCtrlC, tcell.KeyEsc:
 			app.Stop()
+		case tcell.KeyCtrlE:
+			// Search podcasts with whatever is in the search field
+			go func(query string) {
+				podcasts, err := session().ytm.SearchPodcasts(query)
+				if err != nil {
+					log.Printf("podcast search failed: %s", err)
+					return
+				}
+				showingSaved = false
+				showList(createPodcastList(podcasts, openPodcast))
+			}(searchField.GetText())
+			return nil
+		case tcell.KeyCtrlB:
+			// Search albums with whatever is in the search field
+			go func(query string) {
+				albums, err := session().ytm.SearchAlbums(query)
+				if err != nil {
+					log.Printf("album search failed: %s", err)
+					return
+				}
+				showingSaved = false
+				albumList := createAlbumList(albums, openAlbum)
+				albumList.SetChangedFunc(func(_ int, item *cview.ListItem) {
+					if album, ok := item.GetReference().(yt.Album); ok {
+						cover.show(album.Name, album.Thumbnails)
+					}
+				})
+				showList(albumList)
+			}(searchField.GetText())
+			return nil
+		case tcell.KeyCtrlL:
+			go func() {
+				saved, err := session().ytm.SavedEpisodes()
+				if err != nil {
+					log.Printf("failed to get saved episodes: %s", err)
+					return
+				}
+				showingSaved = true
+				showList(createEpisodeList(saved, playSong))
+			}()
+			return nil
+		case tcell.KeyCtrlS:
+			// Save the highlighted episode, or unsave it if we're looking at saved episodes
+			item := songList.GetCurrentItem()
+			if item == nil {
+				return nil
+			}
+			if episode, ok := item.GetReference().(yt.Episode); ok {
+				go func(unsave bool) {
+					var err error
+					if unsave {
+						err = session().ytm.UnsaveEpisode(episode.VideoId)
+					} else {
+						err = session().ytm.SaveEpisode(episode.VideoId)
+					}
+					if err != nil {
+						log.Printf("failed to update saved episodes: %s", err)
+					}
+				}(showingSaved)
+			}
+			return nil
+		case tcell.KeyCtrlT:
+			// Toggle played/unplayed
+			item := songList.GetCurrentItem()
+			if item == nil {
+				return nil
+			}
+			if episode, ok := item.GetReference().(yt.Episode); ok {
+				err := session().episodes.setPlayed(episode.VideoId, !session().episodes.get(episode.VideoId).Played)
+				if err != nil {
+					log.Printf("failed to mark episode: %s", err)
+				}
+				item.SetMainText(episodeLabel(episode))
+			}
+			return nil
+		case tcell.KeyCtrlA:
+			// Account switcher
+			showingSaved = false
+			showList(createProfileList(func(name string) {
+				go func() {
+					progressBarRunner.stop()
+					stopPlayback()
+					if err := switchProfile(name); err != nil {
+						log.Printf("account switch failed: %s", err)
+						return
+					}
+					results, err := session().ytm.Search(query, search.Songs)
+					if err != nil {
+						log.Printf("account switch: search failed: %s", err)
+						return
+					}
+					app.QueueUpdateDraw(func() {
+						playButton.SetLabel(playLabel)
+						frame.Clear()
+						addFrameText(frame)
+					})
+					showList(createSongList(results, playSong))
+				}()
+			}))
+			return nil
+		case tcell.KeyCtrlD:
+			// Debug view
+			showingSaved = false
+			showList(createDebugList(session().ytm.Warnings(), session().prefetcher.Stats()))
+			return nil
+		case tcell.KeyCtrlP:
+			if err := session().outbox.SetPrivate(!session().outbox.Private()); err != nil {
+				log.Printf("private session: %s", err)
+			}
+			frame.Clear()
+			addFrameText(frame)
+			return nil
+		case tcell.KeyCtrlN:
+			skip(true)
+			return nil
+		case tcell.KeyCtrlR:
+			skip(false)
+			return nil
+		case tcell.KeyCtrlU:
+			playQueue.SetShuffle(!playQueue.Shuffle())
+			return nil
+		case tcell.KeyCtrlG:
+			// off, one, all and round again
+			playQueue.SetRepeat((playQueue.Repeat() + 1) % (queue.RepeatAll + 1))
+			return nil
+		case tcell.KeyCtrlQ:
+			showingSaved = false
+			openQueue()
+			return nil
+		case tcell.KeyCtrlO:
+			// Equaliser
+			showingSaved = false
+			showList(createEQList(eq))
+			return nil
+		case tcell.KeyCtrlW, tcell.KeyCtrlY:
+			// Add the highlighted track to the end of the queue, or after
+			// what's playing
+			item := songList.GetCurrentItem()
+			if item == nil {
+				return nil
+			}
+			if _, queued := item.GetReference().(queue.Entry); queued {
+				return nil
+			}
+			if entry, ok := entryFor(item.GetReference()); ok {
+				if event.Key() == tcell.KeyCtrlW {
+					playQueue.Enqueue(entry)
+				} else {
+					playQueue.PlayNext(entry)
+				}
+			}
+			return nil
+		case tcell.KeyLeft, tcell.KeyRight:
+			// Shift skips 5s and Ctrl 30s, plain arrows are left to
+			// whatever has focus
+			var step time.Duration
+			switch {
+			case event.Modifiers()&tcell.ModCtrl != 0:
+				step = time.Second * 30
+			case event.Modifiers()&tcell.ModShift != 0:
+				step = time.Second * 5
+			default:
+				return event
+			}
+			if event.Key() == tcell.KeyLeft {
+				step = -step
+			}
+			seekBy(step)
+			return nil
 		}
 
 		switch event.Rune() {
@@ -388,8 +964,8 @@ func main() {
 		return event
 	})
 
-	frame := cview.NewFrame(rootFlex)
-	frame.AddText("Youtube Music CLI", true, cview.AlignCenter, tcell.ColorAntiqueWhite)
+	frame = cview.NewFrame(rootFlex)
+	addFrameText(frame)
 
 	app.SetRoot(frame, true)
 	app.EnableMouse(true)
@@ -397,20 +973,30 @@ func main() {
 	if err := app.Run(); err != nil {
 		log.Fatalf("Failed to run app: %s", err)
 	}
+	saveEpisodePosition()
+	if err := flushConfig(); err != nil {
+		log.Printf("main(): %s", err)
+	}
+}
+
+func addFrameText(frame *cview.Frame) {
+	frame.AddText("Youtube Music CLI", true, cview.AlignCenter, tcell.ColorAntiqueWhite)
+	frame.AddText(session().name, true, cview.AlignLeft, tcell.ColorGray)
+	if session().outbox.Private() {
+		frame.AddText("private session", true, cview.AlignRight, tcell.ColorGray)
+	}
+	if status := queueStatus(playQueue); status != "" {
+		frame.AddText(status, true, cview.AlignRight, tcell.ColorGray)
+	}
 }
 
-func createSongList(songs []yt.Song,
+func createSongList(songs []yt.Track,
 	selectedFunc func(),
 ) *cview.List {
 	songList := cview.NewList()
 	for _, song := range songs {
-		// Collect the artist names for this song
-		var artistNames []string
-		for _, artist := range song.Artists {
-			artistNames = append(artistNames, artist.Name)
-		}
 		li := cview.NewListItem(song.Title)
-		li.SetSecondaryText(fmt.Sprintf("%s - %s", strings.Join(artistNames, ", "), song.Duration))
+		li.SetSecondaryText(fmt.Sprintf("%s - %s", yt.Names(song.Artists), yt.FormatDuration(song.Duration)))
 		li.SetReference(song)
 		li.SetSelectedFunc(selectedFunc)
 		songList.AddItem(li)
@@ -418,197 +1004,54 @@ func createSongList(songs []yt.Song,
 	return songList
 }
 
-func play(song yt.Song, volume *effects.Volume) error {
-	log.Printf("starting download of %s, ID: %s", song.Title, song.VideoId)
-	err := ytm.DownloadVideo(song.VideoId)
-	if err != nil && !errors.Is(err, yt.ErrAlreadyDownloaded) {
-		return fmt.Errorf("play(): %w", err)
-	}
-	// TODO We always assume mp4 here. We should check the file extension
-	_, streamer, err := loadAudio(filepath.Join(cachePath, song.VideoId+".mp4"))
-	if err != nil {
-		return fmt.Errorf("play(): %w", err)
-	}
-	volume.Streamer = streamer
-	speaker.Clear()
-	speaker.Play(volume)
-	return nil
-}
-
-func loadAudio(path string) (*reisen.Media, beep.Streamer, error) {
-	media, err := reisen.NewMedia(path)
-	if err != nil {
-		return nil, nil, fmt.Errorf("loadAudio(): Unable to create new media %w", err)
-	}
-
-	var sampleSource <-chan [2]float64
-	sampleSource, errs, err := readVideoAndAudio(media)
-	if err != nil {
-		return nil, nil, fmt.Errorf("loadAudio(): %w", err)
-	}
-	go func(errs chan error) {
-		for {
-			err, ok := <-errs
-			if !ok {
-				break
-			} else if err != nil {
-				log.Printf("Decoding error: %s", err)
-			}
+// play stops whatever is playing and starts song from the given offset.
+// ended is called when it plays to the end, see newPlayback().
+func play(song yt.Track, from time.Duration, volume *effects.Volume, ended func(next *playback)) (*playback, error) {
+	stopPlayback()
+	holdPlaying(song.VideoId)
+
+	path, cached := session().ytm.CachedPath(song.VideoId)
+	// Set when playing a download that's still going
+	streaming := false
+	if cached {
+		progressBarRunner.setStatus(nil)
+		if err := session().cacheIndex.Played(song.VideoId); err != nil {
+			log.Printf("play(): %s", err)
 		}
-	}(errs)
-
-	// See https://github.com/faiface/beep/wiki/Making-own-streamers
-	// for reference.
-	// beep.StreamerFunc(func(...)(n int, ok bool)) is a type cast
-	// casting a function to a beep.StreamerFunc
-	streamer := beep.StreamerFunc(func(samples [][2]float64) (n int, ok bool) {
-		numRead := 0
-
-		for i := 0; i < len(samples); i++ {
-			sample, ok := <-sampleSource
-
-			if !ok {
-				numRead = i + 1
-				break
+		session().prefetcher.Set(upcoming())
+	} else {
+		log.Printf("starting download of %s, ID: %s", song.Title, song.VideoId)
+		d := startDownload(song.VideoId)
+		// Our download waits for a prefetch of song to let go of the .part
+		// file and carries on from where it got to
+		session().prefetcher.Set(upcoming())
+		progressBarRunner.setStatus(d.status)
+		d.wait(streamBuffer)
+
+		if !d.done() {
+			fifo, cleanup, err := d.fifo()
+			if err == nil {
+				// The decoder has the fifo open by the time loadAudio() returns
+				defer cleanup()
+				path, streaming = fifo, true
+			} else {
+				log.Printf("play(): %s, waiting for the whole download", err)
 			}
-
-			samples[i] = sample
-			numRead++
-		}
-
-		if numRead < len(samples) {
-			return numRead, false
 		}
-
-		return numRead, true
-	})
-
-	return media, streamer, nil
-}
-
-// https://medium.com/@maximgradan/playing-videos-with-golang-83e67447b111
-// readVideoAndAudio reads video and audio frames
-// from the opened media and sends the decoded
-// data to the channels to be played.
-func readVideoAndAudio(media *reisen.Media) (<-chan [2]float64, chan error, error) {
-	sampleBuffer := make(chan [2]float64, sampleBufferSize)
-	errs := make(chan error, 50)
-
-	err := media.OpenDecode()
-	if err != nil {
-		return nil, nil, fmt.Errorf("readVideoAndAudio(): open decode failed: %w", err)
-	}
-
-	var audioStream *reisen.AudioStream
-	log.Printf("found %d stream(s)", media.StreamCount())
-	for _, stream := range media.Streams() {
-		if stream.Type() == reisen.StreamAudio {
-			audioStream = stream.(*reisen.AudioStream)
-			break
+		if !streaming {
+			var err error
+			if path, err = d.waitFinished(); err != nil {
+				return nil, fmt.Errorf("play(): %w", err)
+			}
 		}
 	}
 
-	if audioStream == nil {
-		return nil, nil, errors.New("readVideoAndAudio(): no audio stream")
-	}
-
-	err = audioStream.Open()
+	streamer, err := loadAudio(path, from, streaming)
 	if err != nil {
-		return nil, nil, fmt.Errorf("readVideoAndAudio(): failed to open audio stream: %w", err)
+		return nil, fmt.Errorf("play(): %w", err)
 	}
-
-	// Clear kill channel
-	func() {
-	L:
-		for {
-			select {
-			case <-decoderKeepAlive:
-			default:
-				break L
-			}
-		}
-	}()
-
-	// Start decoding routine
-	go func() {
-	Loop:
-		for {
-			select {
-			case <-decoderKeepAlive:
-				break Loop
-			default:
-			}
-
-			packet, gotPacket, err := media.ReadPacket()
-			if err != nil {
-				go func(err error) {
-					errs <- fmt.Errorf("readVideoAndAudio(): failed to read media packet: %w", err)
-				}(err)
-			}
-
-			if !gotPacket {
-				log.Printf("readVideoAndAudio(): no packet")
-				break Loop
-			}
-
-			if packet.Type() == reisen.StreamAudio {
-				s := media.Streams()[packet.StreamIndex()].(*reisen.AudioStream)
-
-				audioFrame, gotFrame, err := s.ReadAudioFrame()
-				if err != nil {
-					errs <- fmt.Errorf("readVideoAndAudio(): failed to read audio frame: %w, skipping", err)
-					break Loop
-				}
-
-				if !gotFrame {
-					errs <- fmt.Errorf("readVideoAndAudio(): gotFrame is false. skipping")
-					break Loop
-				}
-				if audioFrame == nil {
-					errs <- fmt.Errorf("readVideoAndAudio(): audio frame is nil. skipping")
-					break Loop
-				}
-
-				// Turn the raw byte data into
-				// audio samples of type [2]float64.
-				reader := bytes.NewReader(audioFrame.Data())
-
-				// See the README.md file for
-				// detailed scheme of the sample structure.
-				for reader.Len() > 0 {
-					sample := [2]float64{0, 0}
-					var result float64
-					err = binary.Read(reader, binary.LittleEndian, &result)
-					if err != nil {
-						go func(err error) {
-							errs <- fmt.Errorf("readVideoAndAudio(): error reading first channel: %w)", err)
-						}(err)
-					}
-
-					sample[0] = result
-
-					err = binary.Read(reader, binary.LittleEndian, &result)
-					if err != nil {
-						go func(err error) {
-							errs <- fmt.Errorf("readVideoAndAudio(): error reading second channel: %w)", err)
-						}(err)
-					}
-
-					sample[1] = result
-					sampleBuffer <- sample
-				}
-			}
-
-		}
-
-		log.Printf("readVideoAndAudio(): decoder goroutine: exiting")
-		progressBarRunner.stop()
-		audioStream.Close()
-		media.CloseDecode()
-		close(sampleBuffer)
-		close(errs)
-		speaker.Clear()
-	}()
-
-	return sampleBuffer, errs, nil
+	p := newPlayback(streamer, loudness.gain(song), ended)
+	speakerDeck.load(p)
+	speaker.Play(volume)
+	return p, nil
 }
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/effects"
+	"github.com/faiface/beep/speaker"
+)
+
+// How long before the end of a track the one after it is opened, enough
+// for its decoder to have filled its buffer by the time it's needed
+const gaplessLead = 15 * time.Second
+
+// playback is one track's decoder and the streamers between it and the
+// deck
+type playback struct {
+	streamer *mediaStreamer
+	position *positionStreamer
+	// Pausing freezes output here, the decoder waits on a full buffer and
+	// carries on from the same sample when unpaused
+	ctrl *beep.Ctrl
+	// Loudness normalisation, ahead of the volume effect
+	gain *effects.Gain
+	// What goes to the deck, which sets it fading in and out
+	fade  *fader
+	ended func(next *playback)
+}
+
+// newPlayback plays streamer scaled by gain, ended is called when it plays
+// to the end with the playback the deck carried on into, if there was one.
+// It isn't called if the track is stopped first.
+func newPlayback(streamer *mediaStreamer, gain float64, ended func(next *playback)) *playback {
+	position := newPositionStreamer(streamer, SpeakerSampleRate.D(streamer.Position()))
+	ctrl := &beep.Ctrl{Streamer: position}
+	// effects.Gain adds its Gain times the sample to the sample
+	normalized := &effects.Gain{Streamer: ctrl, Gain: gain - 1}
+	return &playback{
+		streamer: streamer,
+		position: position,
+		ctrl:     ctrl,
+		gain:     normalized,
+		fade:     &fader{Streamer: normalized},
+		ended:    ended,
+	}
+}
+
+// length is how long the track is, it's unknown until the container says
+func (p *playback) length() (time.Duration, bool) {
+	if p.streamer.Len() <= 0 {
+		return 0, false
+	}
+	return SpeakerSampleRate.D(p.streamer.Len()), true
+}
+
+// remaining is how much of the track is left, it's unknown until the
+// container says how long it is
+func (p *playback) remaining() (time.Duration, bool) {
+	if p.streamer.Len() <= 0 {
+		return 0, false
+	}
+	return SpeakerSampleRate.D(p.streamer.Len() - p.streamer.Position()), true
+}
+
+// err is why the track ended early, if it did
+func (p *playback) err() error {
+	speaker.Lock()
+	defer speaker.Unlock()
+	return p.streamer.Err()
+}
+
+func (p *playback) paused() bool {
+	speaker.Lock()
+	defer speaker.Unlock()
+	return p.ctrl.Paused
+}
+
+func (p *playback) setPaused(paused bool) {
+	speaker.Lock()
+	p.ctrl.Paused = paused
+	speaker.Unlock()
+}
+
+// seek jumps to d into the track
+func (p *playback) seek(d time.Duration) error {
+	speaker.Lock()
+	defer speaker.Unlock()
+	if err := p.streamer.Seek(SpeakerSampleRate.N(d)); err != nil {
+		return err
+	}
+	p.position.reset(SpeakerSampleRate.D(p.streamer.Position()))
+	return nil
+}
+
+// seekBy jumps forwards, or backwards if delta is negative
+func (p *playback) seekBy(delta time.Duration) error {
+	return p.seek(max(p.position.Position()+delta, 0))
+}
+
+// stop stops the decoder and closes the file
+func (p *playback) stop() {
+	speaker.Lock()
+	p.streamer.Close()
+	speaker.Unlock()
+}
+
+// deck is what the speaker plays through the volume effect: the current
+// track and, once it's been opened, the one after it. When the current
+// track runs out part way through a buffer the next one fills the rest, so
+// there's no gap between them, or the two are crossfaded if the config asks
+// for it. Guarded by the speaker lock.
+type deck struct {
+	current *playback
+	next    *playback
+	// Called once per track when it gets near the end, to open the next one
+	// and hand it to setNext()
+	nearEnd func(current *playback)
+	asked   bool
+	// From the config, and whether to use it going into next
+	crossfade time.Duration
+	fadeNext  bool
+	// next is streamed here to be mixed in while crossfading
+	buf [][2]float64
+}
+
+var speakerDeck = &deck{}
+
+func (d *deck) Stream(samples [][2]float64) (n int, ok bool) {
+	for n < len(samples) && d.current != nil {
+		current := d.current
+		if left, known := current.remaining(); known && left < gaplessLead+d.crossfade && !d.asked && d.nearEnd != nil {
+			d.asked = true
+			go d.nearEnd(current)
+		}
+
+		// Both faders start from where current is before it streams
+		fading := d.crossfading(current)
+		m, more := current.fade.Stream(samples[n:])
+		k := 0
+		if fading {
+			k = d.mixNext(samples[n:], m)
+		}
+		n += max(m, k)
+		if more {
+			if m == 0 && k == 0 {
+				// Streamers fill the buffer while they have more, this
+				// one broke that and would have us spinning
+				break
+			}
+			continue
+		}
+
+		d.current, d.next, d.asked = d.next, nil, false
+		if d.current != nil {
+			d.current.fade.Active = false
+		}
+		// Stopping a track ends the stream too
+		if !current.streamer.closed {
+			go current.ended(d.current)
+		}
+	}
+	return n, n > 0 || d.current != nil
+}
+
+// crossfading sets the faders going if current is within the crossfade of
+// its end. It works from how much is left rather than counting samples so
+// seeking in the middle of a crossfade does the right thing. A track that
+// runs on past the length its container gave plays out before next starts.
+func (d *deck) crossfading(current *playback) bool {
+	left, known := current.remaining()
+	if !known || left <= 0 || d.crossfade <= 0 || d.next == nil || !d.fadeNext || left > d.crossfade || current.ctrl.Paused {
+		current.fade.Active = false
+		return false
+	}
+
+	step := 1 / float64(SpeakerSampleRate.N(d.crossfade))
+	progress := 1 - float64(SpeakerSampleRate.N(left))*step
+	*current.fade = fader{Streamer: current.fade.Streamer, Active: true, Progress: progress, Step: step}
+	*d.next.fade = fader{Streamer: d.next.fade.Streamer, Active: true, In: true, Progress: progress, Step: step}
+	return true
+}
+
+// mixNext adds next to samples, of which current filled the first m, and
+// returns how many it added
+func (d *deck) mixNext(samples [][2]float64, m int) int {
+	if cap(d.buf) < len(samples) {
+		d.buf = make([][2]float64, len(samples))
+	}
+	buf := d.buf[:len(samples)]
+	k, _ := d.next.fade.Stream(buf)
+	if k > m {
+		clear(samples[m:k])
+	}
+	for i := range buf[:k] {
+		samples[i][0] += buf[i][0]
+		samples[i][1] += buf[i][1]
+	}
+	return k
+}
+
+func (d *deck) Err() error {
+	return nil
+}
+
+// load puts p on the deck in place of whatever was there
+func (d *deck) load(p *playback) {
+	speaker.Lock()
+	d.current, d.next, d.asked = p, nil, false
+	speaker.Unlock()
+}
+
+// setNext has next follow on from current, crossfading into it if fade is
+// set. It's false if current isn't playing any more or already has
+// something after it.
+func (d *deck) setNext(current, next *playback, fade bool) bool {
+	speaker.Lock()
+	defer speaker.Unlock()
+	if d.current != current || d.next != nil {
+		return false
+	}
+	d.next, d.fadeNext = next, fade
+	return true
+}
+
+// dropNext takes next back off the deck if it hasn't started yet. The deck
+// asks for another when there's time left to open one.
+func (d *deck) dropNext(next *playback) bool {
+	speaker.Lock()
+	defer speaker.Unlock()
+	if d.next != next {
+		return false
+	}
+	d.next, d.asked = nil, false
+	if d.current != nil {
+		d.current.fade.Active = false
+	}
+	return true
+}
+
+// stopPlayback stops whatever is playing, and what was opened to follow
+// it, and lets their decoders go
+func stopPlayback() {
+	if p := nowPlaying.Swap(nil); p != nil {
+		p.stop()
+	}
+	if p := upNext.Swap(nil); p != nil {
+		p.stop()
+	}
+	speakerDeck.load(nil)
+	speaker.Clear()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+// testPlayback streams samples samples of value from position, with the
+// container saying the track is length long
+func testPlayback(length, position, samples int, value float64) *playback {
+	left := samples
+	s := &mediaStreamer{length: length, position: position}
+	s.out = beep.StreamerFunc(func(buf [][2]float64) (int, bool) {
+		if left == 0 {
+			return 0, false
+		}
+		n := min(len(buf), left)
+		for i := range buf[:n] {
+			buf[i] = [2]float64{value, value}
+		}
+		left -= n
+		return n, true
+	})
+	return newPlayback(s, 1, func(*playback) {})
+}
+
+// checkSamples fails unless samples are want for as many as there are of
+// each
+func checkSamples(t *testing.T, samples [][2]float64, want ...[2]float64) {
+	t.Helper()
+	for i, w := range want {
+		if got := samples[i]; got != w {
+			t.Fatalf("sample %d is %v, want %v", i, got, w)
+		}
+	}
+}
+
+func TestDeckOverrun(t *testing.T) {
+	for _, crossfade := range []time.Duration{0, time.Second} {
+		// Ten samples more than the container said, with next set to fade in
+		current := testPlayback(100, 100, 10, 1)
+		next := testPlayback(100, 0, 100, 0.5)
+		d := &deck{current: current, next: next, crossfade: crossfade, fadeNext: true}
+
+		samples := make([][2]float64, 20)
+		if n, ok := d.Stream(samples); n != len(samples) || !ok {
+			t.Fatalf("crossfade %s: streamed %d, %t", crossfade, n, ok)
+		}
+		want := make([][2]float64, 20)
+		for i := range want {
+			want[i] = [2]float64{1, 1}
+			if i >= 10 {
+				want[i] = [2]float64{0.5, 0.5}
+			}
+		}
+		checkSamples(t, samples, want...)
+		if d.current != next || next.fade.Active {
+			t.Fatalf("crossfade %s: the deck didn't go over to next unfaded", crossfade)
+		}
+	}
+}
+
+func TestDeckCrossfade(t *testing.T) {
+	crossfade := time.Second
+	fadeLength := SpeakerSampleRate.N(crossfade)
+	current := testPlayback(fadeLength*2, fadeLength*3/2, fadeLength/2, 1)
+	next := testPlayback(fadeLength*2, 0, fadeLength*2, 1)
+	d := &deck{current: current, next: next, crossfade: crossfade, fadeNext: true}
+
+	samples := make([][2]float64, 1)
+	d.Stream(samples)
+	// Half way through the fade the two are at equal power
+	if got, want := samples[0][0], math.Sqrt2; math.Abs(got-want) > 1e-3 {
+		t.Fatalf("half way through the crossfade got %v, want %v", got, want)
+	}
+}
+
+func TestFaderGain(t *testing.T) {
+	for _, progress := range []float64{-0.5, 0, 0.3, 0.5, 1, 1.5} {
+		in := fader{In: true, Progress: progress}
+		out := fader{Progress: progress}
+		power := in.gain()*in.gain() + out.gain()*out.gain()
+		if math.Abs(power-1) > 1e-9 || math.IsNaN(power) {
+			t.Errorf("progress %v: power is %v, want 1", progress, power)
+		}
+	}
+	if got := (&fader{In: true, Progress: 2}).gain(); got != 1 {
+		t.Errorf("fading in past the end gives %v, want 1", got)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lordxarus/ytmusic_cli/internal/atomicfile"
+)
+
+type playCount struct {
+	// Times played to the end
+	Count        int       `json:"count"`
+	LastFinished time.Time `json:"lastFinished"`
+}
+
+// playStore counts the tracks played all the way through
+type playStore struct {
+	path   string
+	mu     sync.Mutex
+	counts map[string]playCount
+}
+
+func loadPlayStore(path string) (*playStore, error) {
+	store := &playStore{
+		path:   path,
+		counts: make(map[string]playCount),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("loadPlayStore(): %w", err)
+	}
+	if err = json.Unmarshal(data, &store.counts); err != nil {
+		return nil, fmt.Errorf("loadPlayStore(): failed to decode %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// finished records videoId being played to the end
+func (ps *playStore) finished(videoId string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	c := ps.counts[videoId]
+	c.Count++
+	c.LastFinished = time.Now()
+	ps.counts[videoId] = c
+	return ps.save()
+}
+
+// save must be called with mu held
+func (ps *playStore) save() error {
+	data, err := json.MarshalIndent(ps.counts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("playStore.save(): %w", err)
+	}
+	if err = atomicfile.WriteFile(ps.path, data, 0o600); err != nil {
+		return fmt.Errorf("playStore.save(): %w", err)
+	}
+	return nil
+}
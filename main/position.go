@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+// positionStreamer counts the samples that pass through it so we know how
+// far into a track we are. offset is where in the track the count started,
+// it moves when the track is seeked.
+type positionStreamer struct {
+	streamer beep.Streamer
+	offset   atomic.Int64
+	samples  atomic.Int64
+}
+
+func newPositionStreamer(streamer beep.Streamer, offset time.Duration) *positionStreamer {
+	ps := &positionStreamer{streamer: streamer}
+	ps.offset.Store(int64(offset))
+	return ps
+}
+
+func (ps *positionStreamer) Stream(samples [][2]float64) (n int, ok bool) {
+	// A seeker knows how much of what it streamed was the track, see
+	// mediaStreamer.Stream()
+	if seeker, isSeeker := ps.streamer.(beep.StreamSeeker); isSeeker {
+		before := seeker.Position()
+		n, ok = seeker.Stream(samples)
+		ps.samples.Add(int64(seeker.Position() - before))
+		return n, ok
+	}
+	n, ok = ps.streamer.Stream(samples)
+	ps.samples.Add(int64(n))
+	return n, ok
+}
+
+func (ps *positionStreamer) Err() error {
+	return ps.streamer.Err()
+}
+
+func (ps *positionStreamer) Position() time.Duration {
+	return time.Duration(ps.offset.Load()) + SpeakerSampleRate.D(int(ps.samples.Load()))
+}
+
+// reset counts from offset again after a seek
+func (ps *positionStreamer) reset(offset time.Duration) {
+	ps.samples.Store(0)
+	ps.offset.Store(int64(offset))
+}
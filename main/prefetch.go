@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lordxarus/ytmusic_cli/yt"
+	"github.com/lordxarus/ytmusic_cli/yt/cache"
+	"github.com/lordxarus/ytmusic_cli/yt/prefetch"
+)
+
+// Used when the config doesn't say
+const (
+	defaultPrefetch        = 3
+	defaultPrefetchWorkers = 2
+)
+
+func newPrefetcher(client *yt.YTMClient, index *cache.Index) *prefetch.Prefetcher {
+	workers := cfg.PrefetchWorkers
+	if workers <= 0 {
+		workers = defaultPrefetchWorkers
+	}
+	return prefetch.New(func(ctx context.Context, videoId string) error {
+		_, err := client.DownloadVideoContext(ctx, videoId)
+		if err != nil && !errors.Is(err, yt.ErrAlreadyDownloaded) {
+			return err
+		}
+		if err = index.Add(videoId); err != nil {
+			return err
+		}
+		loudness.downloaded(videoId)
+		return nil
+	}, workers)
+}
+
+// upcoming returns the video IDs of the tracks coming up in the queue, as
+// many as the config asks to prefetch
+func upcoming() []string {
+	count := cfg.Prefetch
+	if count == 0 {
+		count = defaultPrefetch
+	}
+
+	var ids []string
+	for _, entry := range playQueue.Upcoming(max(count, 0)) {
+		ids = append(ids, entry.Track.VideoId)
+		// Analysed once downloaded so they start at the right level
+		loudness.want(entry.Track)
+	}
+	return ids
+}
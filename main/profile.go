@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"code.rocketnine.space/tslocum/cview"
+	"github.com/lordxarus/ytmusic_cli/yt"
+	"github.com/lordxarus/ytmusic_cli/yt/cache"
+	"github.com/lordxarus/ytmusic_cli/yt/history"
+	"github.com/lordxarus/ytmusic_cli/yt/prefetch"
+)
+
+// The profile you get without any config. It uses the top level cache,
+// state and credentials paths so setups from before profiles keep working.
+const defaultProfileName = "default"
+
+type profile struct {
+	// Overrides BRAND_ID from .env
+	BrandId string `json:"brandId,omitempty"`
+}
+
+// profileDir is where a profile keeps its files under root
+func profileDir(root string, name string) string {
+	if name == defaultProfileName {
+		return root
+	}
+	return filepath.Join(root, "profiles", name)
+}
+
+// profileSession is everything tied to one profile. switchProfile swaps
+// it as a whole so other goroutines never see half of two profiles.
+type profileSession struct {
+	name            string
+	cachePath       string
+	statePath       string
+	credentialsPath string
+	brandId         string
+
+	// Set by open, nil for subcommands that only need the paths
+	ytm        *yt.YTMClient
+	outbox     *history.Outbox
+	episodes   *episodeStore
+	plays      *playStore
+	cacheIndex *cache.Index
+	prefetcher *prefetch.Prefetcher
+	stopOutbox chan struct{}
+}
+
+var activeSession atomic.Pointer[profileSession]
+
+// session is the active profile, see selectProfile()
+func session() *profileSession {
+	return activeSession.Load()
+}
+
+// validProfileName rejects names that would put a profile's files
+// outside the profiles directory
+func validProfileName(name string) error {
+	if name == "" || name == "." || strings.Contains(name, "..") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+	return nil
+}
+
+// newSession points the cache, state and credentials paths at the named
+// profile. It doesn't open the client, see open for that.
+func newSession(name string) (*profileSession, error) {
+	if name == "" {
+		name = defaultProfileName
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok && name != defaultProfileName {
+		return nil, fmt.Errorf("newSession(): no profile named %q in %s", name, configFile)
+	}
+	if err := validProfileName(name); err != nil {
+		return nil, fmt.Errorf("newSession(): %w", err)
+	}
+
+	s := &profileSession{
+		name:            name,
+		cachePath:       profileDir(cacheRoot, name),
+		statePath:       profileDir(stateRoot, name),
+		credentialsPath: filepath.Join(profileDir(configPath, name), "credentials.json"),
+		brandId:         envBrandId,
+	}
+	if p.BrandId != "" {
+		s.brandId = p.BrandId
+	}
+
+	for _, dir := range []string{s.cachePath, s.statePath} {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, fmt.Errorf("newSession(): %w", err)
+		}
+	}
+	return s, nil
+}
+
+// selectProfile makes the named profile the active one without opening it
+func selectProfile(name string) error {
+	s, err := newSession(name)
+	if err != nil {
+		return fmt.Errorf("selectProfile(): %w", err)
+	}
+	activeSession.Store(s)
+	log.Printf("selectProfile(): using profile %s cache: %s state: %s", s.name, s.cachePath, s.statePath)
+	return nil
+}
+
+// open creates the client, history outbox, episode and play stores for
+// the profile and starts the outbox worker.
+func (s *profileSession) open() error {
+	client, err := s.newClient()
+	if err != nil {
+		return fmt.Errorf("profileSession.open(): failed to create ytm client: %w", err)
+	}
+	client.SetFormatPolicy(cfg.formatPolicy())
+	client.SetDownloaders(cfg.downloaders()...)
+
+	// History reports are queued on disk and sent in the background
+	box, err := history.NewOutbox(filepath.Join(s.statePath, "history_outbox.json"), client)
+	if err != nil {
+		return fmt.Errorf("profileSession.open(): failed to open history outbox: %w", err)
+	}
+
+	store, err := loadEpisodeStore(filepath.Join(s.statePath, "episodes.json"))
+	if err != nil {
+		return fmt.Errorf("profileSession.open(): failed to load episode progress: %w", err)
+	}
+
+	counts, err := loadPlayStore(filepath.Join(s.statePath, "plays.json"))
+	if err != nil {
+		return fmt.Errorf("profileSession.open(): failed to load play counts: %w", err)
+	}
+
+	index, err := s.openCacheIndex()
+	if err != nil {
+		return fmt.Errorf("profileSession.open(): %w", err)
+	}
+
+	s.ytm = client
+	s.outbox = box
+	s.episodes = store
+	s.plays = counts
+	s.cacheIndex = index
+	s.prefetcher = newPrefetcher(client, index)
+	s.stopOutbox = make(chan struct{})
+	go box.Run(s.stopOutbox)
+	return nil
+}
+
+// close stops the outbox worker and prefetches of an open profile
+func (s *profileSession) close() {
+	if s.prefetcher != nil {
+		s.prefetcher.Stop()
+	}
+	if s.stopOutbox != nil {
+		close(s.stopOutbox)
+		s.stopOutbox = nil
+	}
+}
+
+// openProfile opens the active profile, see selectProfile()
+func openProfile() error {
+	if err := session().open(); err != nil {
+		return fmt.Errorf("openProfile(): %w", err)
+	}
+	return nil
+}
+
+// closeProfile closes the active profile
+func closeProfile() {
+	session().close()
+}
+
+// switchProfile opens another profile and makes it the active one. The
+// current profile stays active if that fails.
+func switchProfile(name string) error {
+	saveEpisodePosition()
+
+	next, err := newSession(name)
+	if err == nil {
+		err = next.open()
+	}
+	if err != nil {
+		return fmt.Errorf("switchProfile(): %w", err)
+	}
+	previous := activeSession.Swap(next)
+	previous.close()
+	log.Printf("switchProfile(): using profile %s cache: %s state: %s", next.name, next.cachePath, next.statePath)
+	return nil
+}
+
+func profileNames() []string {
+	names := []string{defaultProfileName}
+	for name := range cfg.Profiles {
+		if name != defaultProfileName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+func createProfileList(switchFunc func(name string)) *cview.List {
+	profileList := cview.NewList()
+	for _, name := range profileNames() {
+		name := name
+		label := name
+		if name == session().name {
+			label = "● " + name
+		}
+		li := cview.NewListItem(label)
+		if brand := cfg.Profiles[name].BrandId; brand != "" {
+			li.SetSecondaryText("brand account " + brand)
+		}
+		li.SetSelectedFunc(func() { switchFunc(name) })
+		profileList.AddItem(li)
+	}
+	return profileList
+}
+
+// parseProfileAdd takes the name and brand from profile add's args. The
+// flags can go before or after the name.
+func parseProfileAdd(args []string) (name string, brand string, err error) {
+	flags := flag.NewFlagSet("profile add", flag.ExitOnError)
+	flags.StringVar(&brand, "brand", "", "brand account ID to use")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		name = flags.Arg(0)
+		flags.Parse(flags.Args()[1:])
+	}
+	if name == "" || flags.NArg() != 0 {
+		return "", "", errors.New("expected a name")
+	}
+	if err = validProfileName(name); err != nil {
+		return "", "", err
+	}
+	return name, brand, nil
+}
+
+func profileCommand(args []string) error {
+	if len(args) == 0 {
+		args = []string{"ls"}
+	}
+
+	switch args[0] {
+	case "ls":
+		for _, name := range profileNames() {
+			marker := " "
+			if name == session().name {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\t%s\n", marker, name, cfg.Profiles[name].BrandId)
+		}
+		return nil
+
+	case "add":
+		name, brand, err := parseProfileAdd(args[1:])
+		if err != nil {
+			return fmt.Errorf("profile add: %w", err)
+		}
+		if cfg.Profiles == nil {
+			cfg.Profiles = make(map[string]profile)
+		}
+		cfg.Profiles[name] = profile{BrandId: brand}
+		if err := cfg.save(configFile); err != nil {
+			return fmt.Errorf("profile add: %w", err)
+		}
+		fmt.Printf("added %s, log in with: %s --profile %s auth\n", name, os.Args[0], name)
+		return nil
+
+	case "rm":
+		if len(args) != 2 {
+			return errors.New("profile rm: expected a name")
+		}
+		delete(cfg.Profiles, args[1])
+		if cfg.DefaultProfile == args[1] {
+			cfg.DefaultProfile = ""
+		}
+		return cfg.save(configFile)
+
+	case "default":
+		if len(args) != 2 {
+			return errors.New("profile default: expected a name")
+		}
+		if _, ok := cfg.Profiles[args[1]]; !ok && args[1] != defaultProfileName {
+			return fmt.Errorf("profile default: no profile named %q", args[1])
+		}
+		cfg.DefaultProfile = args[1]
+		return cfg.save(configFile)
+	}
+	return fmt.Errorf("profile: unknown subcommand %q", args[0])
+}
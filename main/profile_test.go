@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestParseProfileAdd(t *testing.T) {
+	tests := []struct {
+		args  []string
+		name  string
+		brand string
+		ok    bool
+	}{
+		{[]string{"work"}, "work", "", true},
+		{[]string{"work", "-brand", "123"}, "work", "123", true},
+		{[]string{"-brand", "123", "work"}, "work", "123", true},
+		{[]string{"-brand=123", "work"}, "work", "123", true},
+		{[]string{}, "", "", false},
+		{[]string{"-brand", "123"}, "", "", false},
+		{[]string{"work", "home"}, "", "", false},
+		{[]string{"work", "-brand", "123", "home"}, "", "", false},
+		{[]string{"../work"}, "", "", false},
+	}
+	for _, test := range tests {
+		name, brand, err := parseProfileAdd(test.args)
+		if ok := err == nil; ok != test.ok || name != test.name || brand != test.brand {
+			t.Errorf("parseProfileAdd(%q) = %q, %q, %v, want %q, %q, ok %t",
+				test.args, name, brand, err, test.name, test.brand, test.ok)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+
+	"code.rocketnine.space/tslocum/cview"
+	"github.com/gdamore/tcell/v2"
+	"github.com/lordxarus/ytmusic_cli/yt"
+	"github.com/lordxarus/ytmusic_cli/yt/queue"
+)
+
+// entryFor makes a queue entry out of a list item's reference, if it's
+// something we can play
+func entryFor(ref any) (queue.Entry, bool) {
+	switch ref := ref.(type) {
+	case yt.Track:
+		return queue.Entry{Track: ref}, true
+	case yt.Episode:
+		return queue.Entry{Track: ref.Track(), Episode: &ref}, true
+	case queue.Entry:
+		return ref, true
+	}
+	return queue.Entry{}, false
+}
+
+// listEntries returns everything playable in list and where the highlighted
+// item is among them, -1 if it isn't playable
+func listEntries(list *cview.List) ([]queue.Entry, int) {
+	var entries []queue.Entry
+	current := -1
+	for i, item := range list.GetItems() {
+		entry, ok := entryFor(item.GetReference())
+		if !ok {
+			continue
+		}
+		if i == list.GetCurrentItemIndex() {
+			current = len(entries)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, current
+}
+
+// createQueueList shows q with the current entry marked. Selecting an entry
+// jumps to it. Delete removes the highlighted entry, Shift+Up/Down moves it
+// and Ctrl-K clears the queue.
+func createQueueList(q *queue.Queue, jumpFunc func(int)) *cview.List {
+	queueList := cview.NewList()
+	_, current, _ := q.Current()
+	entries := q.Entries()
+	if len(entries) == 0 {
+		queueList.AddItem(cview.NewListItem("queue is empty"))
+		return queueList
+	}
+
+	for i, entry := range entries {
+		i := i
+		label := entry.Track.Title
+		if i == current {
+			label = "▶ " + label
+		}
+		li := cview.NewListItem(label)
+		li.SetSecondaryText(fmt.Sprintf("%s - %s", yt.Names(entry.Track.Artists), yt.FormatDuration(entry.Track.Duration)))
+		li.SetReference(entry)
+		li.SetSelectedFunc(func() { jumpFunc(i) })
+		queueList.AddItem(li)
+	}
+	queueList.SetCurrentItem(max(current, 0))
+
+	queueList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		i := queueList.GetCurrentItemIndex()
+		switch {
+		case event.Key() == tcell.KeyDelete:
+			q.Remove(i)
+		case event.Key() == tcell.KeyCtrlK:
+			q.Clear()
+		// The cursor moves first so it follows the entry when the list
+		// is redrawn
+		case event.Key() == tcell.KeyUp && event.Modifiers()&tcell.ModShift != 0 && i > 0:
+			queueList.SetCurrentItem(i - 1)
+			q.Move(i, i-1)
+		case event.Key() == tcell.KeyDown && event.Modifiers()&tcell.ModShift != 0 && i < len(entries)-1:
+			queueList.SetCurrentItem(i + 1)
+			q.Move(i, i+1)
+		default:
+			return event
+		}
+		return nil
+	})
+	return queueList
+}
+
+// queueStatus is the shuffle and repeat state for the frame, empty when
+// both are off
+func queueStatus(q *queue.Queue) string {
+	status := ""
+	if q.Shuffle() {
+		status = "shuffle"
+	}
+	if repeat := q.Repeat(); repeat != queue.RepeatOff {
+		if status != "" {
+			status += ", "
+		}
+		status += "repeat " + repeat.String()
+	}
+	return status
+}
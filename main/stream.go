@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/lordxarus/ytmusic_cli/yt"
+)
+
+// How much of a download to have before playing it, a few seconds at
+// YouTube's audio bitrates
+const streamBuffer = 256 * 1024
+
+// download is a track downloading in the background that can be played
+// before it's finished. The decoder reads it through a fifo fed by stream()
+// so it blocks instead of hitting the end of a file that's still growing.
+type download struct {
+	videoId string
+
+	mu       sync.Mutex
+	changed  *sync.Cond
+	partPath string
+	written  int64
+	total    int64
+	// Set when stream() has caught up with the download
+	underrun bool
+	finished bool
+	path     string
+	err      error
+}
+
+// startDownload downloads videoId in the background. The download carries on
+// into the cache even if nothing plays it.
+func startDownload(videoId string) *download {
+	d := &download{videoId: videoId}
+	d.changed = sync.NewCond(&d.mu)
+
+	// The download belongs to this profile even if it's switched meanwhile
+	s := session()
+	go func() {
+		path, err := s.ytm.DownloadVideoProgress(context.Background(), videoId, func(partPath string, written, total int64) {
+			d.mu.Lock()
+			d.partPath, d.written, d.total = partPath, written, total
+			d.mu.Unlock()
+			d.changed.Broadcast()
+		})
+		if errors.Is(err, yt.ErrAlreadyDownloaded) {
+			err = nil
+		}
+		if err == nil {
+			if err := s.cacheIndex.Add(videoId); err != nil {
+				log.Printf("startDownload(): %s", err)
+			}
+			loudness.downloaded(videoId)
+		}
+
+		d.mu.Lock()
+		d.finished, d.path, d.err = true, path, err
+		d.mu.Unlock()
+		d.changed.Broadcast()
+	}()
+	return d
+}
+
+// wait blocks until at least n bytes are downloaded or the download is over
+func (d *download) wait(n int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for !d.finished && (d.partPath == "" || d.written < n) {
+		d.changed.Wait()
+	}
+}
+
+// waitFinished blocks until the download is over and returns the path
+func (d *download) waitFinished() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for !d.finished {
+		d.changed.Wait()
+	}
+	return d.path, d.err
+}
+
+func (d *download) done() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.finished
+}
+
+// status is shown on the progress bar
+func (d *download) status() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case d.finished:
+		return ""
+	case d.underrun || d.written < streamBuffer:
+		return "buffering"
+	case d.total > 0:
+		// total can be an estimate
+		return fmt.Sprintf("downloading %d%%", min(d.written*100/d.total, 99))
+	}
+	return "downloading"
+}
+
+// stream copies the download to w as it arrives, until it's finished
+func (d *download) stream(w io.Writer) error {
+	d.wait(1)
+	d.mu.Lock()
+	partPath, dlErr := d.partPath, d.err
+	d.mu.Unlock()
+	if partPath == "" {
+		return fmt.Errorf("download.stream(): nothing was downloaded: %w", dlErr)
+	}
+
+	// The .part file is renamed when it's done, the open file carries on
+	// being readable
+	file, err := os.Open(partPath)
+	if err != nil {
+		return fmt.Errorf("download.stream(): %w", err)
+	}
+	defer file.Close()
+
+	var read int64
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return fmt.Errorf("download.stream(): %w", err)
+			}
+			read += int64(n)
+		}
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			return fmt.Errorf("download.stream(): %w", err)
+		}
+
+		d.mu.Lock()
+		for !d.finished && read >= d.written {
+			d.underrun = true
+			d.changed.Wait()
+		}
+		d.underrun = false
+		finished, written, dlErr := d.finished, d.written, d.err
+		d.mu.Unlock()
+
+		switch {
+		case dlErr != nil:
+			return fmt.Errorf("download.stream(): %w", dlErr)
+		case finished && read >= written:
+			return nil
+		}
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"code.rocketnine.space/tslocum/cview"
@@ -11,11 +13,13 @@ type tickerBar struct {
 	app *cview.Application
 	bar *cview.ProgressBar
 
-	ticker    *time.Ticker
-	killChan  chan bool
-	isRunning bool
+	ticker *time.Ticker
+	mu     sync.Mutex
+	// Closed to stop the run going now, nil when there isn't one
+	kill chan struct{}
 
-	startTime time.Time
+	// Shown as the bar's title, e.g. while a track is still downloading
+	status atomic.Pointer[func() string]
 }
 
 func newTickerBar(
@@ -23,38 +27,76 @@ func newTickerBar(
 	bar *cview.ProgressBar,
 ) *tickerBar {
 	return &tickerBar{
-		app:      app,
-		bar:      bar,
-		ticker:   time.NewTicker(time.Millisecond * 500),
-		killChan: make(chan bool, 1),
+		app:    app,
+		bar:    bar,
+		ticker: time.NewTicker(time.Millisecond * 500),
 	}
 }
 
-func (spb *tickerBar) start(duration time.Duration) {
-	startTime := time.Now()
-	spb.isRunning = true
+// start runs the bar for a track, length says how long it is and position
+// how far into it playback is. It follows the audio rather than the clock
+// so it stands still while buffering. The bar stays empty while the length
+// isn't known.
+func (spb *tickerBar) start(length func() time.Duration, position func() time.Duration) {
+	// Each run gets its own channel, only start and stop touch it, so
+	// stopping one run and starting the next can't stop the new one
+	kill := make(chan struct{})
+	spb.mu.Lock()
+	if spb.kill != nil {
+		close(spb.kill)
+	}
+	spb.kill = kill
+	spb.mu.Unlock()
 	go func() {
 		for {
 			select {
-			case <-spb.killChan:
-				spb.isRunning = false
+			case <-kill:
 				return
 			case <-spb.ticker.C:
-				elapsed := float64(time.Since(startTime)) / float64(duration)
-				percentElapsed := int(math.Floor(elapsed * 100))
+				// Both may be ready, a stopped run mustn't draw
+				select {
+				case <-kill:
+					return
+				default:
+				}
+				percentElapsed := 0
+				if duration := length(); duration > 0 {
+					elapsed := float64(position()) / float64(duration)
+					percentElapsed = min(int(math.Floor(elapsed*100)), 100)
+				}
 				spb.bar.SetProgress(percentElapsed)
+				spb.bar.SetTitle(spb.statusText())
 				app.Draw(spb.bar)
 			}
 		}
 	}()
 }
 
+// setStatus sets what the bar's title says, nil clears it
+func (spb *tickerBar) setStatus(status func() string) {
+	spb.status.Store(&status)
+	spb.bar.SetTitle(spb.statusText())
+	app.Draw(spb.bar)
+}
+
+func (spb *tickerBar) statusText() string {
+	if status := spb.status.Load(); status != nil && *status != nil {
+		return (*status)()
+	}
+	return ""
+}
+
 func (spb *tickerBar) stop() {
-	if spb.isRunning {
-		spb.killChan <- true
+	spb.mu.Lock()
+	defer spb.mu.Unlock()
+	if spb.kill != nil {
+		close(spb.kill)
+		spb.kill = nil
 	}
 }
 
 func (spb *tickerBar) IsRunning() bool {
-	return spb.isRunning
+	spb.mu.Lock()
+	defer spb.mu.Unlock()
+	return spb.kill != nil
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTickerBarRestart(t *testing.T) {
+	// Never ticks, the runs only wait to be stopped
+	spb := &tickerBar{ticker: time.NewTicker(time.Hour)}
+	length := func() time.Duration { return time.Minute }
+	position := func() time.Duration { return 0 }
+	before := runtime.NumGoroutine()
+
+	// What trackEnded() does, stop and straight away start the next track
+	for i := 0; i < 100; i++ {
+		spb.stop()
+		spb.start(length, position)
+		if !spb.IsRunning() {
+			t.Fatalf("round %d: not running after start()", i)
+		}
+	}
+	spb.stop()
+	if spb.IsRunning() {
+		t.Fatal("running after stop()")
+	}
+	spb.stop()
+
+	// From different goroutines too, for the race detector
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			spb.start(length, position)
+		}()
+		go func() {
+			defer wg.Done()
+			spb.stop()
+		}()
+	}
+	wg.Wait()
+	spb.stop()
+
+	// Every run has ended
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("%d goroutines left running, want %d", n, before)
+	}
+}
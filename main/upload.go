@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lordxarus/ytmusic_cli/internal/atomicfile"
+	"github.com/lordxarus/ytmusic_cli/yt"
+)
+
+// uploadRecord is what we remember about a file we've uploaded so
+// running upload on the same directory again skips it.
+type uploadRecord struct {
+	Path       string    `json:"path"`
+	EntityId   string    `json:"entityId,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	UploadedAt time.Time `json:"uploadedAt"`
+}
+
+// uploadManifest is keyed by the sha256 of the file contents
+type uploadManifest map[string]uploadRecord
+
+func uploadCommand(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "ls":
+			return uploadList(args[1:])
+		case "rm":
+			return uploadRemove(args[1:])
+		}
+	}
+
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	wait := flags.Bool("wait", true, "wait for each upload to finish processing")
+	timeout := flags.Duration("timeout", time.Minute*3, "how long to wait for processing")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+		return errors.New("upload: expected a directory")
+	}
+	dir := flags.Arg(0)
+
+	ytm, err := session().newClient()
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+
+	manifestPath := filepath.Join(session().statePath, "uploads.json")
+	manifest, err := loadUploadManifest(manifestPath)
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+
+	var files []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && yt.IsUploadable(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("upload: failed to read %s: %w", dir, err)
+	}
+	if len(files) == 0 {
+		fmt.Printf("no uploadable files in %s (%s)\n", dir, strings.Join(yt.UploadExtensions, " "))
+		return nil
+	}
+
+	// What's there already, so the upload of each file can be told apart
+	existing, err := ytm.UploadedSongs(0)
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	known := make(map[string]bool)
+	for _, song := range existing {
+		known[song.EntityId] = true
+	}
+	// YouTube titles tagged files by their tag, which ffprobe can read
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil && *wait {
+		fmt.Println("no ffprobe on PATH, tagged files can only be matched by their file name")
+	}
+
+	var uploaded, skipped, failed int
+	for i, path := range files {
+		name := filepath.Base(path)
+		prefix := fmt.Sprintf("[%d/%d] %s:", i+1, len(files), name)
+
+		sum, err := hashFile(path)
+		if err != nil {
+			fmt.Println(prefix, "failed:", err)
+			failed++
+			continue
+		}
+		if _, ok := manifest[sum]; ok {
+			fmt.Println(prefix, "already uploaded, skipping")
+			skipped++
+			continue
+		}
+
+		fmt.Println(prefix, "uploading")
+		if _, err = ytm.UploadSong(path); err != nil {
+			fmt.Println(prefix, "failed:", err)
+			failed++
+			continue
+		}
+
+		record := uploadRecord{Path: path, UploadedAt: time.Now()}
+		if *wait {
+			fmt.Println(prefix, "processing")
+			song, err := ytm.WaitForUpload(known, uploadedAs(name, titleTag(ffprobe, path)), time.Second*5, *timeout)
+			switch {
+			case errors.Is(err, yt.ErrUploadTimeout):
+				fmt.Println(prefix, "no upload titled like it after", *timeout, "moving on")
+			case err != nil:
+				fmt.Println(prefix, "couldn't check processing status:", err)
+			default:
+				record.EntityId = song.EntityId
+				record.Title = song.Title
+			}
+		}
+
+		manifest[sum] = record
+		if err = manifest.save(manifestPath); err != nil {
+			return fmt.Errorf("upload: %w", err)
+		}
+		fmt.Println(prefix, "done")
+		uploaded++
+	}
+
+	fmt.Printf("uploaded %d, skipped %d, failed %d\n", uploaded, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("upload: %d file(s) failed", failed)
+	}
+	return nil
+}
+
+func uploadList(args []string) error {
+	kind := "songs"
+	if len(args) > 0 {
+		kind = args[0]
+	}
+
+	ytm, err := session().newClient()
+	if err != nil {
+		return fmt.Errorf("upload ls: %w", err)
+	}
+
+	switch kind {
+	case "songs":
+		songs, err := ytm.UploadedSongs(0)
+		if err != nil {
+			return fmt.Errorf("upload ls: %w", err)
+		}
+		for _, song := range songs {
+			fmt.Printf("%s\t%s - %s\n", song.EntityId, song.Title, yt.Names(song.Artists))
+		}
+	case "albums":
+		albums, err := ytm.UploadedAlbums(0)
+		if err != nil {
+			return fmt.Errorf("upload ls: %w", err)
+		}
+		for _, album := range albums {
+			fmt.Printf("%s\t%s - %s\n", album.ID, album.Name, yt.Names(album.Artists))
+		}
+	case "artists":
+		artists, err := ytm.UploadedArtists(0)
+		if err != nil {
+			return fmt.Errorf("upload ls: %w", err)
+		}
+		for _, artist := range artists {
+			fmt.Printf("%s\t%s (%s)\n", artist.ID, artist.Name, artist.Songs)
+		}
+	default:
+		return fmt.Errorf("upload ls: unknown kind %q, expected songs, albums or artists", kind)
+	}
+	return nil
+}
+
+func uploadRemove(args []string) error {
+	if len(args) == 0 {
+		usage()
+		return errors.New("upload rm: expected at least one entity ID")
+	}
+
+	ytm, err := session().newClient()
+	if err != nil {
+		return fmt.Errorf("upload rm: %w", err)
+	}
+
+	manifestPath := filepath.Join(session().statePath, "uploads.json")
+	manifest, err := loadUploadManifest(manifestPath)
+	if err != nil {
+		return fmt.Errorf("upload rm: %w", err)
+	}
+
+	for _, id := range args {
+		if err = ytm.DeleteUpload(id); err != nil {
+			return fmt.Errorf("upload rm: %w", err)
+		}
+		// Forget it so the file can be uploaded again
+		for sum, record := range manifest {
+			if record.EntityId == id {
+				delete(manifest, sum)
+			}
+		}
+		fmt.Println("deleted", id)
+	}
+	return manifest.save(manifestPath)
+}
+
+func loadUploadManifest(path string) (uploadManifest, error) {
+	manifest := make(uploadManifest)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("loadUploadManifest(): %w", err)
+	}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("loadUploadManifest(): failed to decode %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+func (m uploadManifest) save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("uploadManifest.save(): %w", err)
+	}
+	if err = atomicfile.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("uploadManifest.save(): %w", err)
+	}
+	return nil
+}
+
+// Leading track numbers like "01 - " or "3. "
+var trackNumberPrefix = regexp.MustCompile(`^\d+\s*[-._)]?\s*`)
+
+// uploadedAs matches the song YouTube makes of the file called name, whose
+// title tag is tag. YouTube uses the tag, without one it takes its title
+// from the name, usually without the track number.
+func uploadedAs(name string, tag string) func(yt.Track) bool {
+	stem := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+	bare := trackNumberPrefix.ReplaceAllString(stem, "")
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	return func(song yt.Track) bool {
+		title := strings.ToLower(strings.TrimSpace(song.Title))
+		if tag != "" {
+			return title == tag
+		}
+		return title == stem || title == bare
+	}
+}
+
+// titleTag reads path's title tag with ffprobe, "" if it hasn't got one or
+// there's no ffprobe
+func titleTag(ffprobe string, path string) string {
+	if ffprobe == "" {
+		return ""
+	}
+	// Ogg keeps its tags on the stream rather than the container
+	out, err := exec.Command(ffprobe, "-v", "error",
+		"-show_entries", "format_tags=title:stream_tags=title",
+		"-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		log.Printf("titleTag(): %s: %s", path, err)
+		return ""
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
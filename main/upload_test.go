@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lordxarus/ytmusic_cli/yt"
+)
+
+func TestUploadedAs(t *testing.T) {
+	tests := []struct {
+		name  string
+		tag   string
+		title string
+		want  bool
+	}{
+		{"song.mp3", "", "song", true},
+		{"01 - Song.mp3", "", "Song", true},
+		{"3. Song.flac", "", "3. song", true},
+		{"01 - Song.mp3", "", "Other", false},
+		// Tagged files are titled by their tag, not their name
+		{"01 - track01.mp3", "Real Title", "Real Title", true},
+		{"01 - track01.mp3", "Real Title", "real title ", true},
+		{"01 - track01.mp3", "Real Title", "track01", false},
+		{"Song.ogg", "Song (Live)", "Song", false},
+	}
+	for _, test := range tests {
+		got := uploadedAs(test.name, test.tag)(yt.Track{Title: test.title})
+		if got != test.want {
+			t.Errorf("uploadedAs(%q, %q) on %q: got %t, want %t", test.name, test.tag, test.title, got, test.want)
+		}
+	}
+}
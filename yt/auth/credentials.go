@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lordxarus/ytmusic_cli/internal/atomicfile"
+)
+
+type Kind string
+
+const (
+	KindOAuth   Kind = "oauth"
+	KindBrowser Kind = "browser"
+)
+
+// Refresh this long before the token actually expires so a request
+// doesn't race the expiry
+const refreshSkew = time.Minute
+
+var ErrNoCookie = errors.New("headers have no cookie, copy them from a logged in music.youtube.com request")
+
+// Credentials is what `ytmusic_cli auth` writes to disk
+type Credentials struct {
+	Kind         Kind       `json:"kind"`
+	ClientId     string     `json:"clientId,omitempty"`
+	ClientSecret string     `json:"clientSecret,omitempty"`
+	Endpoints    *Endpoints `json:"endpoints,omitempty"`
+	Token        *Token     `json:"token,omitempty"`
+	// Request headers copied out of the browser
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (c *Credentials) OAuthConfig() *OAuthConfig {
+	cfg := &OAuthConfig{
+		ClientId:     c.ClientId,
+		ClientSecret: c.ClientSecret,
+		Endpoints:    GoogleEndpoints,
+	}
+	if c.Endpoints != nil {
+		cfg.Endpoints = *c.Endpoints
+	}
+	return cfg
+}
+
+// Store owns the credentials file and refreshes OAuth tokens as they expire
+type Store struct {
+	path  string
+	mu    sync.Mutex
+	creds Credentials
+}
+
+func Load(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Load(): %w", err)
+	}
+
+	s := &Store{path: path}
+	if err = json.Unmarshal(data, &s.creds); err != nil {
+		return nil, fmt.Errorf("Load(): failed to decode %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Save writes creds to path, readable only by the current user
+func Save(path string, creds Credentials) (*Store, error) {
+	s := &Store{path: path, creds: creds}
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Credentials returns the stored credentials, refreshing the OAuth token
+// first if it has expired.
+func (s *Store) Credentials(ctx context.Context) (Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.creds.Kind != KindOAuth || s.creds.Token == nil || !s.creds.Token.Expired(refreshSkew) {
+		return s.creds, nil
+	}
+
+	token, err := s.creds.OAuthConfig().Refresh(ctx, *s.creds.Token)
+	if err != nil {
+		return s.creds, fmt.Errorf("Credentials(): token expired and refresh failed, run auth again: %w", err)
+	}
+	s.creds.Token = &token
+	if err = s.save(); err != nil {
+		return s.creds, fmt.Errorf("Credentials(): %w", err)
+	}
+	return s.creds, nil
+}
+
+func (s *Store) Path() string {
+	return s.path
+}
+
+// save must be called with mu held
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.creds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Store.save(): %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("Store.save(): %w", err)
+	}
+	if err = atomicfile.WriteFile(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("Store.save(): %w", err)
+	}
+	return nil
+}
+
+// Headers that would break requests if we replayed them
+var ignoredHeaders = map[string]bool{
+	"host":            true,
+	"content-length":  true,
+	"accept-encoding": true,
+	// HTTP/2 pseudo headers
+	"authority": true,
+	"method":    true,
+	"path":      true,
+	"scheme":    true,
+}
+
+// ParseHeaders reads raw request headers as copied from the browser's dev
+// tools, one "name: value" per line. Firefox's "copy request headers" and
+// Chrome's raw headers view both produce this.
+func ParseHeaders(r io.Reader) (map[string]string, error) {
+	headers := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// Skip the request line, e.g. POST /youtubei/v1/browse HTTP/2
+		name, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimPrefix(name, ":"))
+		if ignoredHeaders[name] || strings.HasPrefix(name, "sec") {
+			continue
+		}
+		headers[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ParseHeaders(): %w", err)
+	}
+	if headers["cookie"] == "" {
+		return nil, ErrNoCookie
+	}
+	if headers["x-goog-authuser"] == "" {
+		headers["x-goog-authuser"] = "0"
+	}
+	return headers, nil
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// The scope ytmusicapi asks for
+	YouTubeScope = "https://www.googleapis.com/auth/youtube"
+
+	grantDeviceCode   = "urn:ietf:params:oauth:grant-type:device_code"
+	grantRefreshToken = "refresh_token"
+)
+
+// Intervals from the server are in these, tests shorten it
+var pollUnit = time.Second
+
+var (
+	ErrAccessDenied = errors.New("user denied access")
+	ErrCodeExpired  = errors.New("device code expired before it was authorized")
+	ErrNoRefresh    = errors.New("token has no refresh token")
+)
+
+// Endpoints can be pointed at a local stand-in server for testing
+type Endpoints struct {
+	DeviceCodeURL string
+	TokenURL      string
+}
+
+var GoogleEndpoints = Endpoints{
+	DeviceCodeURL: "https://oauth2.googleapis.com/device/code",
+	TokenURL:      "https://oauth2.googleapis.com/token",
+}
+
+type OAuthConfig struct {
+	ClientId     string
+	ClientSecret string
+	Scope        string
+	Endpoints    Endpoints
+	// http.DefaultClient when nil
+	HTTPClient *http.Client
+}
+
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// Token uses the same field names as ytmusicapi's oauth.json so it can be
+// handed straight to YTMusic()
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
+	ExpiresIn    int    `json:"expires_in"`
+	// Unix seconds
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// Expired reports whether the token expires within skew
+func (t Token) Expired(skew time.Duration) bool {
+	return time.Now().Add(skew).Unix() >= t.ExpiresAt
+}
+
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// RequestDeviceCode starts the device flow. Show the user VerificationURL
+// and UserCode then call PollToken.
+func (c *OAuthConfig) RequestDeviceCode(ctx context.Context) (DeviceCode, error) {
+	var code DeviceCode
+	form := url.Values{
+		"client_id": {c.ClientId},
+		"scope":     {c.scope()},
+	}
+	if _, err := c.post(ctx, c.Endpoints.DeviceCodeURL, form, &code); err != nil {
+		return code, fmt.Errorf("RequestDeviceCode(): %w", err)
+	}
+	if code.Interval <= 0 {
+		code.Interval = 5
+	}
+	return code, nil
+}
+
+// PollToken waits for the user to authorize the device code
+func (c *OAuthConfig) PollToken(ctx context.Context, code DeviceCode) (Token, error) {
+	form := url.Values{
+		"client_id":     {c.ClientId},
+		"client_secret": {c.ClientSecret},
+		"device_code":   {code.DeviceCode},
+		"grant_type":    {grantDeviceCode},
+	}
+	interval := time.Duration(code.Interval) * pollUnit
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * pollUnit)
+
+	for {
+		var token Token
+		oerr, err := c.post(ctx, c.Endpoints.TokenURL, form, &token)
+		switch {
+		case err == nil:
+			token.ExpiresAt = time.Now().Unix() + int64(token.ExpiresIn)
+			return token, nil
+		case oerr == nil:
+			return token, fmt.Errorf("PollToken(): %w", err)
+		case oerr.Error == "authorization_pending":
+		case oerr.Error == "slow_down":
+			interval += pollUnit * 5
+		case oerr.Error == "access_denied":
+			return token, ErrAccessDenied
+		case oerr.Error == "expired_token":
+			return token, ErrCodeExpired
+		default:
+			return token, fmt.Errorf("PollToken(): %w", err)
+		}
+
+		if code.ExpiresIn > 0 && time.Now().Add(interval).After(deadline) {
+			return token, ErrCodeExpired
+		}
+		select {
+		case <-ctx.Done():
+			return token, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Refresh exchanges the refresh token for a new access token. The refresh
+// token is carried over since Google doesn't send a new one.
+func (c *OAuthConfig) Refresh(ctx context.Context, token Token) (Token, error) {
+	if token.RefreshToken == "" {
+		return token, ErrNoRefresh
+	}
+	form := url.Values{
+		"client_id":     {c.ClientId},
+		"client_secret": {c.ClientSecret},
+		"refresh_token": {token.RefreshToken},
+		"grant_type":    {grantRefreshToken},
+	}
+
+	var fresh Token
+	if _, err := c.post(ctx, c.Endpoints.TokenURL, form, &fresh); err != nil {
+		return token, fmt.Errorf("Refresh(): %w", err)
+	}
+	if fresh.RefreshToken == "" {
+		fresh.RefreshToken = token.RefreshToken
+	}
+	fresh.ExpiresAt = time.Now().Unix() + int64(fresh.ExpiresIn)
+	return fresh, nil
+}
+
+func (c *OAuthConfig) scope() string {
+	if c.Scope == "" {
+		return YouTubeScope
+	}
+	return c.Scope
+}
+
+// post sends form to endpoint and decodes the response into v. When the
+// server answers with an OAuth error it's returned as well as err.
+func (c *OAuthConfig) post(ctx context.Context, endpoint string, form url.Values, v any) (*oauthError, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var oerr oauthError
+		if json.Unmarshal(body, &oerr) == nil && oerr.Error != "" {
+			return &oerr, fmt.Errorf("%s: %s %s", endpoint, oerr.Error, oerr.Description)
+		}
+		return nil, fmt.Errorf("%s: unexpected status %s", endpoint, resp.Status)
+	}
+
+	if err = json.Unmarshal(body, v); err != nil {
+		return nil, fmt.Errorf("%s: unable to unmarshal JSON: %w", endpoint, err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGoogle stands in for Google's device flow. The token endpoint answers
+// each device code poll with the next of responses, an OAuth error code or
+// "" for a token.
+type fakeGoogle struct {
+	t         *testing.T
+	mu        sync.Mutex
+	responses []string
+	polls     []time.Time
+	refreshed string
+}
+
+func (f *fakeGoogle) start() (*httptest.Server, OAuthConfig) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device/code", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("client_id") != "client" || r.PostFormValue("scope") != YouTubeScope {
+			f.t.Errorf("device code request: unexpected form %v", r.PostForm)
+		}
+		json.NewEncoder(w).Encode(DeviceCode{
+			DeviceCode:      "device",
+			UserCode:        "ABCD-EFGH",
+			VerificationURL: "https://example.com/device",
+			ExpiresIn:       1800,
+			Interval:        1,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		switch r.PostFormValue("grant_type") {
+		case grantRefreshToken:
+			f.refreshed = r.PostFormValue("refresh_token")
+			json.NewEncoder(w).Encode(Token{AccessToken: "fresh", TokenType: "Bearer", ExpiresIn: 3600})
+			return
+		case grantDeviceCode:
+		default:
+			f.t.Errorf("token request: unexpected grant type %q", r.PostFormValue("grant_type"))
+		}
+		if r.PostFormValue("device_code") != "device" {
+			f.t.Errorf("token request: unexpected device code %q", r.PostFormValue("device_code"))
+		}
+
+		f.polls = append(f.polls, time.Now())
+		response := f.responses[0]
+		if len(f.responses) > 1 {
+			f.responses = f.responses[1:]
+		}
+		if response != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(oauthError{Error: response})
+			return
+		}
+		json.NewEncoder(w).Encode(Token{
+			AccessToken:  "access",
+			RefreshToken: "refresh",
+			TokenType:    "Bearer",
+			ExpiresIn:    3600,
+		})
+	})
+
+	server := httptest.NewServer(mux)
+	return server, OAuthConfig{
+		ClientId:     "client",
+		ClientSecret: "secret",
+		Endpoints: Endpoints{
+			DeviceCodeURL: server.URL + "/device/code",
+			TokenURL:      server.URL + "/token",
+		},
+		HTTPClient: server.Client(),
+	}
+}
+
+func shortPolls(t *testing.T) {
+	unit := pollUnit
+	pollUnit = time.Millisecond * 10
+	t.Cleanup(func() { pollUnit = unit })
+}
+
+func TestDeviceFlow(t *testing.T) {
+	shortPolls(t)
+	fake := &fakeGoogle{t: t, responses: []string{"authorization_pending", "slow_down", "authorization_pending", ""}}
+	server, config := fake.start()
+	defer server.Close()
+
+	ctx := context.Background()
+	code, err := config.RequestDeviceCode(ctx)
+	if err != nil {
+		t.Fatalf("RequestDeviceCode(): %s", err)
+	}
+	if code.UserCode != "ABCD-EFGH" || code.Interval != 1 {
+		t.Fatalf("RequestDeviceCode(): unexpected code %+v", code)
+	}
+
+	token, err := config.PollToken(ctx, code)
+	if err != nil {
+		t.Fatalf("PollToken(): %s", err)
+	}
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Errorf("PollToken(): unexpected token %+v", token)
+	}
+	if token.Expired(time.Minute) {
+		t.Errorf("PollToken(): new token has already expired, expires at %d", token.ExpiresAt)
+	}
+
+	if len(fake.polls) != 4 {
+		t.Fatalf("PollToken(): polled %d times, expected 4", len(fake.polls))
+	}
+	// slow_down was the second answer, the wait after it grows by 5
+	// intervals and stays that way
+	before := fake.polls[1].Sub(fake.polls[0])
+	after := fake.polls[2].Sub(fake.polls[1])
+	if after < 6*pollUnit || after < before {
+		t.Errorf("PollToken(): waited %s after slow_down, %s before it", after, before)
+	}
+	if last := fake.polls[3].Sub(fake.polls[2]); last < 6*pollUnit {
+		t.Errorf("PollToken(): waited %s after slow_down stopped, expected it to stay slowed down", last)
+	}
+}
+
+func TestDeviceFlowErrors(t *testing.T) {
+	shortPolls(t)
+	for _, tc := range []struct {
+		response string
+		want     error
+	}{
+		{"access_denied", ErrAccessDenied},
+		{"expired_token", ErrCodeExpired},
+	} {
+		fake := &fakeGoogle{t: t, responses: []string{"authorization_pending", tc.response}}
+		server, config := fake.start()
+
+		_, err := config.PollToken(context.Background(), DeviceCode{DeviceCode: "device", Interval: 1})
+		if !errors.Is(err, tc.want) {
+			t.Errorf("PollToken() after %s: got %v, expected %v", tc.response, err, tc.want)
+		}
+		server.Close()
+	}
+}
+
+func TestPollTokenCancelled(t *testing.T) {
+	shortPolls(t)
+	fake := &fakeGoogle{t: t, responses: []string{"authorization_pending"}}
+	server, config := fake.start()
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pollUnit*5)
+	defer cancel()
+	if _, err := config.PollToken(ctx, DeviceCode{DeviceCode: "device", Interval: 1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("PollToken(): got %v, expected the context's error", err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	fake := &fakeGoogle{t: t}
+	server, config := fake.start()
+	defer server.Close()
+
+	old := Token{AccessToken: "stale", RefreshToken: "refresh", ExpiresAt: time.Now().Unix() - 10}
+	if !old.Expired(0) {
+		t.Fatal("Expired(): token from the past isn't expired")
+	}
+	fresh, err := config.Refresh(context.Background(), old)
+	if err != nil {
+		t.Fatalf("Refresh(): %s", err)
+	}
+	if fake.refreshed != "refresh" {
+		t.Errorf("Refresh(): sent refresh token %q", fake.refreshed)
+	}
+	// Google doesn't send the refresh token again
+	if fresh.AccessToken != "fresh" || fresh.RefreshToken != "refresh" || fresh.Expired(time.Minute) {
+		t.Errorf("Refresh(): unexpected token %+v", fresh)
+	}
+
+	if _, err = config.Refresh(context.Background(), Token{AccessToken: "stale"}); !errors.Is(err, ErrNoRefresh) {
+		t.Errorf("Refresh() without a refresh token: got %v, expected ErrNoRefresh", err)
+	}
+}
@@ -0,0 +1,413 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lordxarus/ytmusic_cli/internal/atomicfile"
+)
+
+var ErrNotCached = errors.New("not in the cache")
+
+// Entry is one download in the Index
+type Entry struct {
+	Meta
+	Size       int64     `json:"size"`
+	Added      time.Time `json:"added"`
+	LastPlayed time.Time `json:"lastPlayed"`
+	// Pinned entries are never evicted
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// Index keeps track of what's in a cache directory and evicts the least
+// recently played downloads once it's over MaxSize. The downloads
+// themselves are written by yt.DownloadVideo(), tell the index about them
+// with Add() or Played().
+type Index struct {
+	dir string
+	// Zero means no limit
+	maxSize int64
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	// Kept from being evicted, see Hold()
+	held map[string]bool
+}
+
+// Open loads the index of dir and brings it up to date with what's on disk
+func Open(dir string, maxSize int64) (*Index, error) {
+	x := &Index{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*Entry),
+	}
+
+	if err := x.scan(); err != nil {
+		return nil, fmt.Errorf("Open(): %w", err)
+	}
+	return x, nil
+}
+
+func (x *Index) path() string {
+	return filepath.Join(x.dir, "index.json")
+}
+
+// index.lock is held while the index is changed, the player and the cache
+// subcommand can have the same directory open
+func (x *Index) lockPath() string {
+	return filepath.Join(x.dir, "index.lock")
+}
+
+// lock takes mu and the lock file then reloads the index, so changes made by
+// another process since are kept. Call the returned func to unlock.
+func (x *Index) lock() (func(), error) {
+	x.mu.Lock()
+	unlockFile, err := lockFile(x.lockPath())
+	if err != nil {
+		x.mu.Unlock()
+		return nil, err
+	}
+	unlock := func() {
+		unlockFile()
+		x.mu.Unlock()
+	}
+	if err = x.load(); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// load must be called with the index locked
+func (x *Index) load() error {
+	data, err := os.ReadFile(x.path())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]*Entry)
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", x.path(), err)
+	}
+	x.entries = entries
+	return nil
+}
+
+func (x *Index) Dir() string {
+	return x.dir
+}
+
+func (x *Index) MaxSize() int64 {
+	return x.maxSize
+}
+
+// scan adds downloads the index doesn't know about, e.g. from before it
+// existed, and drops entries whose file has gone
+func (x *Index) scan() error {
+	unlock, err := x.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for id, entry := range x.entries {
+		if _, err := os.Stat(filepath.Join(x.dir, entry.File)); err != nil {
+			delete(x.entries, id)
+		}
+	}
+
+	files, err := os.ReadDir(x.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(file.Name())
+		id := strings.TrimSuffix(file.Name(), ext)
+		if _, ok := x.entries[id]; ok || !isDownload(ext) {
+			continue
+		}
+		meta, ok := LoadMeta(x.dir, id)
+		if !ok {
+			meta = Meta{VideoId: id, File: file.Name()}
+		}
+		if meta.File != file.Name() {
+			continue
+		}
+		x.add(meta)
+	}
+	return x.save()
+}
+
+func isDownload(ext string) bool {
+	for _, e := range Extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// add must be called with mu held
+func (x *Index) add(meta Meta) *Entry {
+	entry := &Entry{Meta: meta}
+	if info, err := os.Stat(filepath.Join(x.dir, meta.File)); err == nil {
+		entry.Size = info.Size()
+		entry.Added = info.ModTime()
+		entry.LastPlayed = info.ModTime()
+	}
+	x.entries[meta.VideoId] = entry
+	return entry
+}
+
+// Add indexes a finished download and evicts old ones if that puts the
+// cache over its limit
+func (x *Index) Add(videoId string) error {
+	return x.update(videoId, false)
+}
+
+// Played is Add() but also marks the download as just played
+func (x *Index) Played(videoId string) error {
+	return x.update(videoId, true)
+}
+
+func (x *Index) update(videoId string, played bool) error {
+	unlock, err := x.lock()
+	if err != nil {
+		return fmt.Errorf("Index.update(): %w", err)
+	}
+	defer unlock()
+
+	entry, ok := x.entries[videoId]
+	switch {
+	case !ok:
+		if _, found := Find(x.dir, videoId); !found {
+			return fmt.Errorf("Index.update(): %s: %w", videoId, ErrNotCached)
+		}
+		meta, _ := LoadMeta(x.dir, videoId)
+		entry = x.add(meta)
+		entry.Added = time.Now()
+	case entry.ContentLength == 0:
+		// Indexed before the downloader checked it and wrote its Meta
+		if meta, found := LoadMeta(x.dir, videoId); found {
+			old := *entry
+			entry = x.add(meta)
+			entry.Added, entry.LastPlayed, entry.Pinned = old.Added, old.LastPlayed, old.Pinned
+		}
+	}
+	if played {
+		entry.LastPlayed = time.Now()
+	}
+
+	if _, err := x.evict(x.maxSize, videoId); err != nil {
+		return fmt.Errorf("Index.update(): %w", err)
+	}
+	if err := x.save(); err != nil {
+		return fmt.Errorf("Index.update(): %w", err)
+	}
+	return nil
+}
+
+// Hold keeps videoIds from being evicted until the next call, e.g. what's
+// playing and what's opened to play next, whose files are being read.
+// Unlike Pin() it only lasts as long as this Index.
+func (x *Index) Hold(videoIds ...string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.held = make(map[string]bool, len(videoIds))
+	for _, id := range videoIds {
+		x.held[id] = true
+	}
+}
+
+// Pin stops videoId being evicted, or lets it be again
+func (x *Index) Pin(videoId string, pinned bool) error {
+	unlock, err := x.lock()
+	if err != nil {
+		return fmt.Errorf("Index.Pin(): %w", err)
+	}
+	defer unlock()
+
+	entry, ok := x.entries[videoId]
+	if !ok {
+		return fmt.Errorf("Index.Pin(): %s: %w", videoId, ErrNotCached)
+	}
+	entry.Pinned = pinned
+	if err := x.save(); err != nil {
+		return fmt.Errorf("Index.Pin(): %w", err)
+	}
+	return nil
+}
+
+// Remove deletes videoId's download and everything that goes with it
+func (x *Index) Remove(videoId string) error {
+	unlock, err := x.lock()
+	if err != nil {
+		return fmt.Errorf("Index.Remove(): %w", err)
+	}
+	defer unlock()
+
+	entry, ok := x.entries[videoId]
+	if !ok {
+		return fmt.Errorf("Index.Remove(): %s: %w", videoId, ErrNotCached)
+	}
+	if err := x.remove(entry); err != nil {
+		return fmt.Errorf("Index.Remove(): %w", err)
+	}
+	if err := x.save(); err != nil {
+		return fmt.Errorf("Index.Remove(): %w", err)
+	}
+	return nil
+}
+
+// remove must be called with mu held
+func (x *Index) remove(entry *Entry) error {
+	for _, path := range []string{
+		filepath.Join(x.dir, entry.File),
+		filepath.Join(x.dir, entry.File+".part"),
+		MetaPath(x.dir, entry.VideoId),
+		LoudnessPath(x.dir, entry.VideoId),
+	} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	delete(x.entries, entry.VideoId)
+	return nil
+}
+
+// Prune evicts the least recently played downloads until the cache fits in
+// maxSize. Pinned downloads are kept even if that means it doesn't.
+func (x *Index) Prune(maxSize int64) ([]Entry, error) {
+	unlock, err := x.lock()
+	if err != nil {
+		return nil, fmt.Errorf("Index.Prune(): %w", err)
+	}
+	defer unlock()
+
+	evicted, err := x.evict(maxSize, "")
+	if err != nil {
+		return evicted, fmt.Errorf("Index.Prune(): %w", err)
+	}
+	if err = x.save(); err != nil {
+		return evicted, fmt.Errorf("Index.Prune(): %w", err)
+	}
+	return evicted, nil
+}
+
+// evict must be called with mu held. keep is never evicted, it's what was
+// just added or played, and neither is anything held.
+func (x *Index) evict(maxSize int64, keep string) ([]Entry, error) {
+	if maxSize <= 0 {
+		return nil, nil
+	}
+
+	var evicted []Entry
+	for _, entry := range x.leastRecent() {
+		if x.size() <= maxSize {
+			break
+		}
+		if entry.Pinned || entry.VideoId == keep || x.held[entry.VideoId] {
+			continue
+		}
+		if err := x.remove(entry); err != nil {
+			return evicted, err
+		}
+		evicted = append(evicted, *entry)
+	}
+	return evicted, nil
+}
+
+// Verify checks every download against its Meta. Downloads with no size to
+// check, like those from before Meta, count as bad. Bad ones are removed if
+// fix is set so they're downloaded again next time they're played.
+func (x *Index) Verify(fix bool) (map[string]error, error) {
+	unlock, err := x.lock()
+	if err != nil {
+		return nil, fmt.Errorf("Index.Verify(): %w", err)
+	}
+	defer unlock()
+
+	bad := make(map[string]error)
+	for id, entry := range x.entries {
+		// The downloader may have checked and written Meta since it was indexed
+		if meta, ok := LoadMeta(x.dir, id); ok {
+			entry.Meta = meta
+		}
+		err := Check(x.dir, entry.Meta)
+		if err == nil {
+			continue
+		}
+		bad[id] = err
+		if fix {
+			if err = x.remove(entry); err != nil {
+				return bad, fmt.Errorf("Index.Verify(): %w", err)
+			}
+		}
+	}
+	if err := x.save(); err != nil {
+		return bad, fmt.Errorf("Index.Verify(): %w", err)
+	}
+	return bad, nil
+}
+
+// Entries returns every download, most recently played first
+func (x *Index) Entries() []Entry {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	sorted := x.leastRecent()
+	entries := make([]Entry, len(sorted))
+	for i, entry := range sorted {
+		entries[len(sorted)-1-i] = *entry
+	}
+	return entries
+}
+
+// Size is the total size of the downloads in bytes
+func (x *Index) Size() int64 {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.size()
+}
+
+func (x *Index) size() int64 {
+	var total int64
+	for _, entry := range x.entries {
+		total += entry.Size
+	}
+	return total
+}
+
+// leastRecent must be called with mu held
+func (x *Index) leastRecent() []*Entry {
+	entries := make([]*Entry, 0, len(x.entries))
+	for _, entry := range x.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastPlayed.Before(entries[j].LastPlayed)
+	})
+	return entries
+}
+
+// save must be called with mu held
+func (x *Index) save() error {
+	data, err := json.MarshalIndent(x.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(x.path(), data, 0o644)
+}
@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// download writes a finished download of size bytes last played ago
+func download(t *testing.T, dir string, videoId string, size int, ago time.Duration) {
+	t.Helper()
+	meta := Meta{VideoId: videoId, File: videoId + ".webm", ContentLength: int64(size)}
+	path := filepath.Join(dir, meta.File)
+	if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveMeta(dir, meta); err != nil {
+		t.Fatal(err)
+	}
+	when := time.Now().Add(-ago)
+	if err := os.Chtimes(path, when, when); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func videoIds(entries []Entry) []string {
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.VideoId)
+	}
+	return ids
+}
+
+func checkEntries(t *testing.T, x *Index, want ...string) {
+	t.Helper()
+	if got := videoIds(x.Entries()); !slices.Equal(got, want) {
+		t.Fatalf("entries are %v, want %v", got, want)
+	}
+	for _, id := range want {
+		if _, ok := Find(x.Dir(), id); !ok {
+			t.Fatalf("%s isn't in the cache directory", id)
+		}
+	}
+}
+
+func TestEvictLeastRecent(t *testing.T) {
+	dir := t.TempDir()
+	download(t, dir, "a", 100, 3*time.Hour)
+	download(t, dir, "b", 100, 2*time.Hour)
+	download(t, dir, "c", 100, time.Hour)
+	x, err := Open(dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, x, "c", "b", "a")
+
+	if err = x.Played("a"); err != nil {
+		t.Fatal(err)
+	}
+	// Playing it evicted b, the least recently played
+	checkEntries(t, x, "a", "c")
+	if _, ok := Find(dir, "b"); ok {
+		t.Fatal("b is still in the cache directory")
+	}
+	if _, ok := LoadMeta(dir, "b"); ok {
+		t.Fatal("b's meta is still there")
+	}
+
+	evicted, err := x.Prune(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := videoIds(evicted); !slices.Equal(got, []string{"c"}) {
+		t.Fatalf("evicted %v, want c", got)
+	}
+	checkEntries(t, x, "a")
+}
+
+func TestPinAndHold(t *testing.T) {
+	dir := t.TempDir()
+	download(t, dir, "a", 100, 3*time.Hour)
+	download(t, dir, "b", 100, 2*time.Hour)
+	download(t, dir, "c", 100, time.Hour)
+	x, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = x.Pin("a", true); err != nil {
+		t.Fatal(err)
+	}
+	x.Hold("b")
+	evicted, err := x.Prune(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := videoIds(evicted); !slices.Equal(got, []string{"c"}) {
+		t.Fatalf("evicted %v, want c", got)
+	}
+	checkEntries(t, x, "b", "a")
+
+	// Let go of, b goes but the pin is saved
+	x.Hold()
+	if _, err = x.Prune(100); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, x, "a")
+	if err = x.Pin("b", true); !errors.Is(err, ErrNotCached) {
+		t.Fatalf("pinning an evicted download gave %v, want ErrNotCached", err)
+	}
+
+	reopened, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := reopened.Entries(); len(entries) != 1 || !entries[0].Pinned {
+		t.Fatalf("reopened entries are %+v, want a pinned", entries)
+	}
+}
+
+func TestSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	x, err := Open(dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, id := range []string{"a", "b", "c", "d"} {
+		download(t, dir, id, 100, time.Duration(10-i)*time.Minute)
+		if err = x.Add(id); err != nil {
+			t.Fatal(err)
+		}
+		if size := x.Size(); size > 250 {
+			t.Fatalf("cache is %d bytes after adding %s, want at most 250", size, id)
+		}
+	}
+	checkEntries(t, x, "d", "c")
+
+	// What was just added stays even if it's over the limit on its own
+	download(t, dir, "e", 300, 0)
+	if err = x.Add("e"); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, x, "e")
+
+	if err = x.Add("missing"); !errors.Is(err, ErrNotCached) {
+		t.Fatalf("adding a missing download gave %v, want ErrNotCached", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	download(t, dir, "a", 100, 0)
+	if _, ok := Find(dir, "a"); !ok {
+		t.Fatal("a wasn't found")
+	}
+
+	// Cut short
+	if err := os.Truncate(filepath.Join(dir, "a.webm"), 50); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Find(dir, "a"); ok {
+		t.Fatal("a truncated download was found")
+	}
+
+	// No size to check against
+	if err := SaveMeta(dir, Meta{VideoId: "a", File: "a.webm"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(dir, Meta{VideoId: "a", File: "a.webm"}); !errors.Is(err, ErrUnverified) {
+		t.Fatalf("checking without a size gave %v, want ErrUnverified", err)
+	}
+	if _, ok := Find(dir, "a"); ok {
+		t.Fatal("an unverified download was found")
+	}
+}
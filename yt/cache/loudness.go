@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/lordxarus/ytmusic_cli/internal/atomicfile"
+	"github.com/lordxarus/ytmusic_cli/internal/audio"
+)
+
+// Loudness is written beside a download once it's been analysed, see
+// audio.Meter
+type Loudness struct {
+	VideoId string `json:"videoId"`
+	// So the tracks of an album can be found to work out its loudness
+	AlbumId string `json:"albumId,omitempty"`
+	// Integrated loudness in LUFS and true peak in dBTP
+	Integrated float64      `json:"integrated"`
+	TruePeak   float64      `json:"truePeak"`
+	Blocks     audio.Blocks `json:"blocks"`
+}
+
+func LoudnessPath(dir string, videoId string) string {
+	return filepath.Join(dir, videoId+loudnessSuffix)
+}
+
+const loudnessSuffix = ".loudness.json"
+
+func LoadLoudness(dir string, videoId string) (Loudness, bool) {
+	return loadLoudness(LoudnessPath(dir, videoId))
+}
+
+func loadLoudness(path string) (Loudness, bool) {
+	var loudness Loudness
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return loudness, false
+	}
+	if err = json.Unmarshal(data, &loudness); err != nil || loudness.VideoId == "" {
+		log.Printf("loadLoudness(): ignoring %s: %v", path, err)
+		return loudness, false
+	}
+	return loudness, true
+}
+
+func SaveLoudness(dir string, loudness Loudness) error {
+	data, err := json.Marshal(loudness)
+	if err != nil {
+		return fmt.Errorf("SaveLoudness(): %w", err)
+	}
+	if err = atomicfile.WriteFile(LoudnessPath(dir, loudness.VideoId), data, 0o644); err != nil {
+		return fmt.Errorf("SaveLoudness(): %w", err)
+	}
+	return nil
+}
+
+// AlbumLoudness returns the analyses of every download in dir that's from
+// an album, by album ID
+func AlbumLoudness(dir string) (map[string][]Loudness, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+loudnessSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("AlbumLoudness(): %w", err)
+	}
+	albums := make(map[string][]Loudness)
+	for _, path := range paths {
+		if loudness, ok := loadLoudness(path); ok && loudness.AlbumId != "" {
+			albums[loudness.AlbumId] = append(albums[loudness.AlbumId], loudness)
+		}
+	}
+	return albums, nil
+}
@@ -0,0 +1,121 @@
+// Package cache manages the downloaded audio in a cache directory.
+//
+// A cache directory holds:
+//
+//	<videoId>.<ext>            a finished download
+//	<videoId>.<ext>.part       a download in progress
+//	<videoId>.meta.json        Meta for the download
+//	<videoId>.loudness.json    Loudness of the download, once it's analysed
+//	index.json                 the Index
+//	index.lock                 held while index.json is changed
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/lordxarus/ytmusic_cli/internal/atomicfile"
+)
+
+// Extensions a download can have
+var Extensions = []string{".webm", ".m4a", ".mp4"}
+
+// Meta is written beside every download. It lets an interrupted download
+// carry on with the same format and a finished one be checked against the
+// size it should be.
+type Meta struct {
+	VideoId string `json:"videoId"`
+	// Name of the finished file in the cache directory
+	File     string `json:"file"`
+	Itag     int    `json:"itag,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	// Size of the finished file. YouTube doesn't always say, the downloader
+	// fills it in once the download is done then.
+	ContentLength int64  `json:"contentLength,omitempty"`
+	Title         string `json:"title,omitempty"`
+	Author        string `json:"author,omitempty"`
+}
+
+func MetaPath(dir string, videoId string) string {
+	return filepath.Join(dir, videoId+".meta.json")
+}
+
+func LoadMeta(dir string, videoId string) (Meta, bool) {
+	var meta Meta
+	data, err := os.ReadFile(MetaPath(dir, videoId))
+	if err != nil {
+		return meta, false
+	}
+	if err = json.Unmarshal(data, &meta); err != nil || meta.File == "" {
+		log.Printf("LoadMeta(): ignoring %s: %v", MetaPath(dir, videoId), err)
+		return meta, false
+	}
+	return meta, true
+}
+
+func SaveMeta(dir string, meta Meta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("SaveMeta(): %w", err)
+	}
+	if err = atomicfile.WriteFile(MetaPath(dir, meta.VideoId), data, 0o644); err != nil {
+		return fmt.Errorf("SaveMeta(): %w", err)
+	}
+	return nil
+}
+
+// ErrUnverified is returned by Check for a download with no size to check
+// it against, e.g. one from before Meta existed
+var ErrUnverified = errors.New("size unknown, can't verify")
+
+// Check returns an error if the download described by meta is missing or
+// isn't the size it should be
+func Check(dir string, meta Meta) error {
+	info, err := os.Stat(filepath.Join(dir, meta.File))
+	if err != nil {
+		return err
+	}
+	if meta.ContentLength == 0 {
+		return fmt.Errorf("%s: %w", meta.File, ErrUnverified)
+	}
+	if info.Size() != meta.ContentLength {
+		return fmt.Errorf("%s is %d bytes, expected %d", meta.File, info.Size(), meta.ContentLength)
+	}
+	return nil
+}
+
+// Find returns the path of videoId's finished download if it's there and
+// passes Check(). Downloads from before Meta existed aren't trusted, see
+// Legacy().
+func Find(dir string, videoId string) (string, bool) {
+	meta, ok := LoadMeta(dir, videoId)
+	if !ok {
+		return "", false
+	}
+	if err := Check(dir, meta); err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Find(): %s", err)
+		}
+		return "", false
+	}
+	return filepath.Join(dir, meta.File), true
+}
+
+// Legacy returns the path of a download of videoId that has no Meta. The
+// downloader checks its size before keeping it.
+func Legacy(dir string, videoId string) (string, bool) {
+	if _, ok := LoadMeta(dir, videoId); ok {
+		return "", false
+	}
+	for _, ext := range Extensions {
+		path := filepath.Join(dir, videoId+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,226 @@
+package yt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How many warnings the client holds on to for the debug view
+const maxWarnings = 200
+
+// Warning is a problem decoding one item of a response. The item was either
+// skipped or kept with some fields left empty, see Skipped.
+type Warning struct {
+	Endpoint string `json:"endpoint"`
+	// JSONPath-ish location of the item or field, e.g. $[0].contents[3].year
+	Path    string    `json:"path"`
+	Message string    `json:"message"`
+	Skipped bool      `json:"skipped"`
+	Time    time.Time `json:"time"`
+}
+
+func (w Warning) String() string {
+	action := "kept"
+	if w.Skipped {
+		action = "skipped"
+	}
+	return fmt.Sprintf("%s %s: %s (%s)", w.Endpoint, w.Path, w.Message, action)
+}
+
+// decoder decodes one response, collecting warnings instead of giving up
+// on the first malformed item
+type decoder struct {
+	endpoint string
+	warnings []Warning
+}
+
+func newDecoder(endpoint string) *decoder {
+	return &decoder{endpoint: endpoint}
+}
+
+func (d *decoder) warn(path string, err error, skipped bool) {
+	d.warnings = append(d.warnings, Warning{
+		Endpoint: d.endpoint,
+		Path:     path,
+		Message:  err.Error(),
+		Skipped:  skipped,
+		Time:     time.Now(),
+	})
+}
+
+// isTypeError is true when json managed to decode everything except a field
+// with an unexpected type
+func isTypeError(err error) bool {
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &typeErr)
+}
+
+// decodeItem decodes a single item. An item with a field of the wrong type is
+// kept with that field empty, anything worse is skipped.
+func decodeItem[T any](d *decoder, raw json.RawMessage, path string) (T, bool) {
+	var item T
+	err := json.Unmarshal(raw, &item)
+	if err == nil {
+		return item, true
+	}
+
+	// No field means the item itself was the wrong type, e.g. a string
+	// where an object should be
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		d.warn(fieldPath(path, typeErr.Field), err, false)
+		return item, true
+	}
+
+	d.warn(path, err, true)
+	return item, false
+}
+
+// fieldPath appends field, as json reports it, to the item's path. json
+// joins the whole path from the item with dots, including array indexes,
+// e.g. artists.0.name becomes path.artists[0].name
+func fieldPath(path string, field string) string {
+	var b strings.Builder
+	b.WriteString(path)
+	for _, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+		} else {
+			b.WriteString("." + part)
+		}
+	}
+	return b.String()
+}
+
+// json drops the path to a field inside a type with its own UnmarshalJSON,
+// a bad artist name on a track comes back as just "name". Those fields are
+// decoded separately with decodeField or decodeFieldList to put it back.
+
+// decodeField decodes the field named field of an object into a T
+func decodeField[T any](data json.RawMessage, field string) (T, error) {
+	var v T
+	if len(data) == 0 {
+		return v, nil
+	}
+	return v, withField(json.Unmarshal(data, &v), field)
+}
+
+// decodeFieldList is decodeField for a list, keeping the index of a bad item
+func decodeFieldList[T any](data json.RawMessage, field string) ([]T, error) {
+	var raws []json.RawMessage
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, withField(err, field)
+	}
+
+	var items []T
+	var first error
+	for i, raw := range raws {
+		var item T
+		if err := json.Unmarshal(raw, &item); err != nil && first == nil {
+			first = withField(err, field+"."+strconv.Itoa(i))
+		}
+		items = append(items, item)
+	}
+	return items, first
+}
+
+// withField puts field in front of a type error's field
+func withField(err error, field string) error {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+	prefixed := *typeErr
+	prefixed.Field = field
+	if typeErr.Field != "" {
+		prefixed.Field += "." + typeErr.Field
+	}
+	return &prefixed
+}
+
+// firstError is the first of errs that isn't nil
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeList decodes a JSON array item by item. It only fails when data
+// isn't an array at all.
+func decodeList[T any](d *decoder, data []byte, path string) ([]T, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, fmt.Errorf("%s: expected a list at %s: %w", d.endpoint, path, err)
+	}
+
+	items := make([]T, 0, len(raws))
+	for i, raw := range raws {
+		if item, ok := decodeItem[T](d, raw, fmt.Sprintf("%s[%d]", path, i)); ok {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// decodeShelves is decodeList for get_home(), which nests a list of items
+// in each shelf
+func decodeShelves(d *decoder, data []byte) ([]Shelf, error) {
+	type shelfJSON struct {
+		Title    string            `json:"title"`
+		Contents []json.RawMessage `json:"contents"`
+	}
+	raws, err := decodeList[shelfJSON](d, data, "$")
+	if err != nil {
+		return nil, err
+	}
+
+	shelves := make([]Shelf, 0, len(raws))
+	for i, raw := range raws {
+		shelf := Shelf{Title: raw.Title}
+		for j, content := range raw.Contents {
+			if item, ok := decodeItem[Item](d, content, fmt.Sprintf("$[%d].contents[%d]", i, j)); ok {
+				shelf.Contents = append(shelf.Contents, item)
+			}
+		}
+		shelves = append(shelves, shelf)
+	}
+	return shelves, nil
+}
+
+// record keeps the warnings from d for the debug view
+func (ytm *YTMClient) record(d *decoder) {
+	if len(d.warnings) == 0 {
+		return
+	}
+	ytm.warningsMu.Lock()
+	defer ytm.warningsMu.Unlock()
+
+	for _, w := range d.warnings {
+		log.Printf("decode warning: %s", w)
+	}
+	ytm.warnings = append(ytm.warnings, d.warnings...)
+	if over := len(ytm.warnings) - maxWarnings; over > 0 {
+		ytm.warnings = ytm.warnings[over:]
+	}
+}
+
+// Warnings returns the most recent decode warnings, oldest first
+func (ytm *YTMClient) Warnings() []Warning {
+	ytm.warningsMu.Lock()
+	defer ytm.warningsMu.Unlock()
+
+	warnings := make([]Warning, len(ytm.warnings))
+	copy(warnings, ytm.warnings)
+	return warnings
+}
@@ -0,0 +1,52 @@
+package yt
+
+import "testing"
+
+func TestDecodeListWarningPaths(t *testing.T) {
+	data := []byte(`[
+		{"videoId": "a", "title": "A", "artists": [{"name": "x"}, {"name": 5}]},
+		"not a track",
+		{"videoId": "c", "title": "C", "thumbnails": [{"url": "u"}, {"url": "v", "width": "wide"}]},
+		{"videoId": "d", "title": "D", "album": {"name": "B", "artists": [{"name": ["x"]}]}}
+	]`)
+
+	d := newDecoder("test")
+	tracks, err := decodeList[Track](d, data, "$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 3 || tracks[0].VideoId != "a" || tracks[1].VideoId != "c" || tracks[2].Album.Name != "B" {
+		t.Fatalf("got tracks %+v, want a, c and d", tracks)
+	}
+
+	want := []struct {
+		path    string
+		skipped bool
+	}{
+		{"$[0].artists[1].name", false},
+		{"$[1]", true},
+		{"$[2].thumbnails[1].width", false},
+		{"$[3].album.artists[0].name", false},
+	}
+	if len(d.warnings) != len(want) {
+		t.Fatalf("got warnings %v, want %d", d.warnings, len(want))
+	}
+	for i, w := range want {
+		if d.warnings[i].Path != w.path || d.warnings[i].Skipped != w.skipped {
+			t.Errorf("warning %d: got %s skipped %t, want %s skipped %t", i, d.warnings[i].Path, d.warnings[i].Skipped, w.path, w.skipped)
+		}
+	}
+}
+
+func TestFieldPath(t *testing.T) {
+	for _, tc := range []struct{ field, want string }{
+		{"year", "$[3].year"},
+		{"album.name", "$[3].album.name"},
+		{"artists.0.name", "$[3].artists[0].name"},
+		{"contents.2.thumbnails.10", "$[3].contents[2].thumbnails[10]"},
+	} {
+		if got := fieldPath("$[3]", tc.field); got != tc.want {
+			t.Errorf("fieldPath(%q) = %q, want %q", tc.field, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package yt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/lordxarus/ytmusic_cli/yt/cache"
+)
+
+// googlevideo throttles requests for a whole file so it's fetched in
+// pieces, the same size kkdai/youtube uses
+const downloadChunkSize = 10 * 1024 * 1024
+
+// CachedPath returns where videoId was downloaded to, if it has been and the
+// file is the size it should be
+func (ytm *YTMClient) CachedPath(videoId string) (string, bool) {
+	return cache.Find(ytm.cachePath, videoId)
+}
+
+// DownloadVideo downloads the audio of videoId into the cache and returns
+// the path. The extension is the real container, see Container(). If it's
+// already cached the path comes back with ErrAlreadyDownloaded.
+//
+// The download goes to a .part file which is only renamed into place once
+// it's the expected size. An interrupted download picks up where it left
+// off next time, even if it's finished by a different Downloader.
+func (ytm *YTMClient) DownloadVideo(videoId string) (string, error) {
+	return ytm.DownloadVideoContext(context.Background(), videoId)
+}
+
+// DownloadVideoContext is DownloadVideo() but stops when ctx is cancelled.
+// Whatever was downloaded so far is kept for next time. If videoId is
+// already being downloaded this waits for that to finish instead.
+func (ytm *YTMClient) DownloadVideoContext(ctx context.Context, videoId string) (string, error) {
+	return ytm.DownloadVideoProgress(ctx, videoId, nil)
+}
+
+// Progress is told how much of a download has been written to partPath so
+// far. total is zero when YouTube didn't give a size, and may only be an
+// estimate when it came from yt-dlp.
+type Progress func(partPath string, written int64, total int64)
+
+// DownloadVideoProgress is DownloadVideoContext() but calls progress as the
+// .part file grows, so it can be read before the download is finished.
+func (ytm *YTMClient) DownloadVideoProgress(ctx context.Context, videoId string, progress Progress) (string, error) {
+	unlock, err := ytm.lockDownload(ctx, videoId)
+	if err != nil {
+		return "", fmt.Errorf("DownloadVideo(): %w", err)
+	}
+	defer unlock()
+
+	if path, ok := ytm.CachedPath(videoId); ok {
+		log.Println("DownloadVideo(): already downloaded", videoId)
+		return path, ErrAlreadyDownloaded
+	}
+
+	downloaders := ytm.downloaders
+	if len(downloaders) == 0 {
+		downloaders, _ = ParseDownloaders("", "")
+	}
+	var errs []error
+	for _, downloader := range downloaders {
+		path, err := ytm.download(ctx, downloader, videoId, progress)
+		if err == nil {
+			return path, nil
+		}
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("DownloadVideo(): %w", err)
+		}
+		log.Printf("DownloadVideo(): %s failed with %s: %s", videoId, downloader.Name(), err)
+		errs = append(errs, fmt.Errorf("%s: %w", downloader.Name(), err))
+	}
+	return "", fmt.Errorf("DownloadVideo(): %s: %w", videoId, errors.Join(errs...))
+}
+
+// download is one attempt at DownloadVideoProgress() with downloader
+func (ytm *YTMClient) download(ctx context.Context, downloader Downloader, videoId string, progress Progress) (string, error) {
+	// Carry on with the format we started with so the bytes we have still fit
+	previous, resuming := cache.LoadMeta(ytm.cachePath, videoId)
+	pick := func(formats youtube.FormatList) (*youtube.Format, error) {
+		if resuming {
+			if same := formats.Itag(previous.Itag); len(same) > 0 {
+				return &same[0], nil
+			}
+		}
+		return SelectFormat(formats, ytm.formatPolicy)
+	}
+	stream, err := downloader.Stream(ctx, videoId, pick)
+	if err != nil {
+		return "", err
+	}
+	format := stream.Format
+
+	meta := cache.Meta{
+		VideoId:       videoId,
+		File:          videoId + Container(format.MimeType),
+		Itag:          format.ItagNo,
+		MimeType:      format.MimeType,
+		ContentLength: format.ContentLength,
+		Title:         stream.Title,
+		Author:        stream.Author,
+	}
+	log.Printf("download(): %s using %s itag %d, %s at %d bps", videoId, downloader.Name(), format.ItagNo, format.MimeType, bitrate(format))
+
+	fullPath := filepath.Join(ytm.cachePath, meta.File)
+	partPath := fullPath + ".part"
+	if legacy, ok := cache.Legacy(ytm.cachePath, videoId); ok {
+		adopted, err := adoptLegacy(ctx, legacy, fullPath, stream, &meta)
+		if err != nil {
+			return "", err
+		}
+		if adopted {
+			return fullPath, cache.SaveMeta(ytm.cachePath, meta)
+		}
+	}
+	switch {
+	case resuming && previous.Itag != meta.Itag:
+		// The old format is gone, so is whatever we had of it
+		os.Remove(filepath.Join(ytm.cachePath, previous.File))
+		os.Remove(filepath.Join(ytm.cachePath, previous.File+".part"))
+	case resuming:
+		// A file that failed CachedPath() is most likely a download that
+		// was cut short before .part files, finish it off
+		if _, err = os.Stat(fullPath); err == nil {
+			if err = os.Rename(fullPath, partPath); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err = cache.SaveMeta(ytm.cachePath, meta); err != nil {
+		return "", err
+	}
+
+	var report func(int64)
+	if progress != nil {
+		total := meta.ContentLength
+		if total == 0 {
+			total = stream.ApproxLength
+		}
+		report = func(written int64) { progress(partPath, written, total) }
+	}
+	if err = fetch(ctx, http.DefaultClient, stream.URL, stream.Header, partPath, meta.ContentLength, report); err != nil {
+		return "", fmt.Errorf("failed to download to %s: %w", partPath, err)
+	}
+
+	info, err := os.Stat(partPath)
+	if err != nil {
+		return "", err
+	}
+	if meta.ContentLength > 0 && info.Size() != meta.ContentLength {
+		// Start over next time
+		os.Remove(partPath)
+		return "", fmt.Errorf("%s is %d bytes, expected %d", partPath, info.Size(), meta.ContentLength)
+	}
+	if meta.ContentLength == 0 {
+		// The server sent all there was, remember how much so the file
+		// can be checked later
+		meta.ContentLength = info.Size()
+		if err = cache.SaveMeta(ytm.cachePath, meta); err != nil {
+			return "", err
+		}
+	}
+	if err = os.Rename(partPath, fullPath); err != nil {
+		return "", err
+	}
+
+	return fullPath, nil
+}
+
+// adoptLegacy decides what to do with a download from before Meta existed.
+// It's kept, and meta given its size, if it's the format we'd download and
+// the size the server says that is. Otherwise it's removed.
+func adoptLegacy(ctx context.Context, legacy string, fullPath string, stream Stream, meta *cache.Meta) (bool, error) {
+	info, err := os.Stat(legacy)
+	if err != nil {
+		return false, nil
+	}
+	length := meta.ContentLength
+	if length == 0 && legacy == fullPath {
+		if length, err = probeLength(ctx, http.DefaultClient, stream.URL, stream.Header); err != nil {
+			log.Printf("adoptLegacy(): %s", err)
+		}
+	}
+	if legacy == fullPath && length > 0 && info.Size() == length {
+		log.Printf("adoptLegacy(): keeping %s", legacy)
+		meta.ContentLength = length
+		return true, nil
+	}
+
+	log.Printf("adoptLegacy(): %s can't be verified, downloading again", legacy)
+	if err = os.Remove(legacy); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// probeLength asks the server how big url is without downloading it
+func probeLength(ctx context.Context, client *http.Client, url string, header http.Header) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	addHeader(req, header)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HEAD %s", resp.Status)
+	}
+	return max(resp.ContentLength, 0), nil
+}
+
+// lockDownload stops two downloads of the same video writing to the same
+// .part file. It blocks until any other download of videoId is done.
+func (ytm *YTMClient) lockDownload(ctx context.Context, videoId string) (func(), error) {
+	for {
+		ytm.downloadsMu.Lock()
+		if ytm.downloads == nil {
+			ytm.downloads = make(map[string]chan struct{})
+		}
+		busy, ok := ytm.downloads[videoId]
+		if !ok {
+			done := make(chan struct{})
+			ytm.downloads[videoId] = done
+			ytm.downloadsMu.Unlock()
+			return func() {
+				ytm.downloadsMu.Lock()
+				delete(ytm.downloads, videoId)
+				ytm.downloadsMu.Unlock()
+				close(done)
+			}, nil
+		}
+		ytm.downloadsMu.Unlock()
+
+		select {
+		case <-busy:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// fetch downloads url to path with range requests, starting from however
+// much of it path already has. header goes with every request. length is
+// the expected size, if it's zero the rest of the file is asked for in one
+// go. report, if set, is given the size of path as it grows.
+func fetch(ctx context.Context, client *http.Client, url string, header http.Header, path string, length int64, report func(int64)) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if length > 0 && offset > length {
+		log.Printf("fetch(): %s is bigger than expected, starting over", path)
+		if offset, err = 0, file.Truncate(0); err != nil {
+			return err
+		}
+	}
+	if offset > 0 {
+		log.Printf("fetch(): resuming %s from byte %d", path, offset)
+	}
+	if report != nil {
+		report(offset)
+	}
+
+	for length == 0 || offset < length {
+		end := ""
+		if length > 0 {
+			end = fmt.Sprint(min(offset+downloadChunkSize, length) - 1)
+		}
+		next, done, err := fetchRange(ctx, client, url, header, file, offset, end, report)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
+		}
+		if next == offset {
+			return fmt.Errorf("no data at byte %d", offset)
+		}
+		offset = next
+	}
+	return file.Sync()
+}
+
+// fetchRange writes bytes offset to end of url into file at offset. An
+// empty end means the rest of the file. It returns the offset to carry on
+// from, done is set once there's nothing left to fetch.
+func fetchRange(ctx context.Context, client *http.Client, url string, header http.Header, file *os.File, offset int64, end string, report func(int64)) (next int64, done bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return offset, false, err
+	}
+	addHeader(req, header)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%s", offset, end))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return offset, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The server ignored the range and is sending all of it
+		if offset > 0 {
+			if err = file.Truncate(0); err != nil {
+				return offset, false, err
+			}
+			offset = 0
+		}
+		end = ""
+	case http.StatusRequestedRangeNotSatisfiable:
+		// We already have everything
+		return offset, true, nil
+	default:
+		return offset, false, fmt.Errorf("range %d-%s: %s", offset, end, resp.Status)
+	}
+
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return offset, false, err
+	}
+	n, err := io.Copy(&progressWriter{w: file, written: offset, report: report}, resp.Body)
+	return offset + n, end == "" && err == nil, err
+}
+
+// addHeader copies header into req, keeping anything req already has
+func addHeader(req *http.Request, header http.Header) {
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+}
+
+// progressWriter reports the running total of what's been written through it
+type progressWriter struct {
+	w       io.Writer
+	written int64
+	report  func(int64)
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += int64(n)
+	if pw.report != nil && n > 0 {
+		pw.report(pw.written)
+	}
+	return n, err
+}
@@ -0,0 +1,209 @@
+package yt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+var ErrNoDownloader = errors.New("no downloader available")
+
+// Stream is a format of a video and the URL to fetch it from
+type Stream struct {
+	Format youtube.Format
+	URL    string
+	// Sent with every request for URL, as well as the Range
+	Header http.Header
+	// An estimate of the size when Format has no ContentLength. It's only
+	// good for showing progress, the download isn't checked against it.
+	ApproxLength int64
+	Title        string
+	Author       string
+}
+
+// Downloader finds the stream to download for a video. The bytes are
+// fetched the same way whichever one found it, so a download started by one
+// can be finished by another.
+type Downloader interface {
+	Name() string
+	// Stream lists videoId's formats, chooses one with pick and returns
+	// where to fetch it from
+	Stream(ctx context.Context, videoId string, pick func(youtube.FormatList) (*youtube.Format, error)) (Stream, error)
+}
+
+// KkdaiDownloader deciphers stream URLs in process with kkdai/youtube. It's
+// fast but breaks whenever YouTube changes its player until the library
+// catches up.
+type KkdaiDownloader struct {
+	client youtube.Client
+}
+
+func (d *KkdaiDownloader) Name() string {
+	return "kkdai"
+}
+
+func (d *KkdaiDownloader) Stream(ctx context.Context, videoId string, pick func(youtube.FormatList) (*youtube.Format, error)) (Stream, error) {
+	video, err := d.client.GetVideoContext(ctx, videoId)
+	if err != nil {
+		return Stream{}, fmt.Errorf("KkdaiDownloader.Stream(): failed to get video: %w", err)
+	}
+	format, err := pick(video.Formats)
+	if err != nil {
+		return Stream{}, fmt.Errorf("KkdaiDownloader.Stream(): %s: %w", videoId, err)
+	}
+	url, err := d.client.GetStreamURLContext(ctx, video, format)
+	if err != nil {
+		return Stream{}, fmt.Errorf("KkdaiDownloader.Stream(): failed to get stream: %w", err)
+	}
+	return Stream{Format: *format, URL: url, Title: video.Title, Author: video.Author}, nil
+}
+
+// YtDlpDownloader asks the yt-dlp binary for stream URLs. It's slower to
+// start but is usually fixed within a day of YouTube breaking it.
+type YtDlpDownloader struct {
+	// Path to yt-dlp, found on PATH if empty
+	Path string
+}
+
+func (d *YtDlpDownloader) Name() string {
+	return "yt-dlp"
+}
+
+// Just the parts of yt-dlp's --dump-json output we use
+type ytDlpInfo struct {
+	Title    string `json:"title"`
+	Uploader string `json:"uploader"`
+	Artist   string `json:"artist"`
+	Formats  []struct {
+		FormatId string  `json:"format_id"`
+		URL      string  `json:"url"`
+		Protocol string  `json:"protocol"`
+		Ext      string  `json:"ext"`
+		ACodec   string  `json:"acodec"`
+		VCodec   string  `json:"vcodec"`
+		ABR      float64 `json:"abr"`
+		TBR      float64 `json:"tbr"`
+		Filesize int64   `json:"filesize"`
+		// yt-dlp's guess from the bitrate and duration
+		FilesizeApprox int64             `json:"filesize_approx"`
+		AudioChannels  int               `json:"audio_channels"`
+		Width          int               `json:"width"`
+		Height         int               `json:"height"`
+		HTTPHeaders    map[string]string `json:"http_headers"`
+	} `json:"formats"`
+}
+
+func (d *YtDlpDownloader) Stream(ctx context.Context, videoId string, pick func(youtube.FormatList) (*youtube.Format, error)) (Stream, error) {
+	path := d.Path
+	if path == "" {
+		var err error
+		if path, err = exec.LookPath("yt-dlp"); err != nil {
+			return Stream{}, fmt.Errorf("YtDlpDownloader.Stream(): %w: %w", ErrNoDownloader, err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "--dump-json", "--no-playlist", "--no-warnings", "--", "https://music.youtube.com/watch?v="+videoId)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return Stream{}, fmt.Errorf("YtDlpDownloader.Stream(): yt-dlp failed: %w", err)
+	}
+
+	var info ytDlpInfo
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		return Stream{}, fmt.Errorf("YtDlpDownloader.Stream(): failed to decode yt-dlp output: %w", err)
+	}
+
+	var formats youtube.FormatList
+	streams := make(map[int]Stream)
+	for _, f := range info.Formats {
+		// Manifests and storyboards can't be fetched with range requests,
+		// and the ids that aren't plain itags are variants like 251-drc
+		itag, err := strconv.Atoi(f.FormatId)
+		if err != nil || f.URL == "" || f.Protocol != "https" {
+			continue
+		}
+		format := youtube.Format{
+			ItagNo:         itag,
+			MimeType:       ytDlpMimeType(f.Ext, f.VCodec, f.ACodec),
+			Bitrate:        int(f.TBR * 1000),
+			AverageBitrate: int(f.ABR * 1000),
+			Width:          f.Width,
+			Height:         f.Height,
+			ContentLength:  f.Filesize,
+			AudioChannels:  f.AudioChannels,
+		}
+		if f.ACodec != "none" && format.AudioChannels == 0 {
+			format.AudioChannels = 2
+		}
+		formats = append(formats, format)
+
+		header := make(http.Header, len(f.HTTPHeaders))
+		for key, value := range f.HTTPHeaders {
+			header.Set(key, value)
+		}
+		streams[itag] = Stream{URL: f.URL, Header: header, ApproxLength: f.FilesizeApprox}
+	}
+
+	format, err := pick(formats)
+	if err != nil {
+		return Stream{}, fmt.Errorf("YtDlpDownloader.Stream(): %s: %w", videoId, err)
+	}
+	author := info.Artist
+	if author == "" {
+		author = info.Uploader
+	}
+	stream := streams[format.ItagNo]
+	stream.Format, stream.Title, stream.Author = *format, info.Title, author
+	return stream, nil
+}
+
+// ytDlpMimeType builds the mime type YouTube would have given the format,
+// which is what Codec() and Container() work from
+func ytDlpMimeType(ext string, vcodec string, acodec string) string {
+	kind := "video"
+	codecs := acodec
+	if vcodec == "none" {
+		kind = "audio"
+	} else if acodec == "none" {
+		codecs = vcodec
+	} else {
+		codecs = vcodec + ", " + acodec
+	}
+	if ext == "m4a" {
+		ext = "mp4"
+	}
+	return fmt.Sprintf(`%s/%s; codecs="%s"`, kind, ext, codecs)
+}
+
+// ParseDownloaders returns the downloaders to try in order, preferred
+// first. ytDlpPath is where to find yt-dlp, empty looks on PATH.
+func ParseDownloaders(preferred string, ytDlpPath string) ([]Downloader, error) {
+	kkdai := &KkdaiDownloader{}
+	ytDlp := &YtDlpDownloader{Path: ytDlpPath}
+	switch strings.ToLower(preferred) {
+	case "", "kkdai":
+		return []Downloader{kkdai, ytDlp}, nil
+	case "yt-dlp":
+		return []Downloader{ytDlp, kkdai}, nil
+	}
+	return []Downloader{kkdai, ytDlp}, fmt.Errorf("ParseDownloaders(): unknown downloader %q, expected kkdai or yt-dlp", preferred)
+}
+
+// SetDownloaders changes which downloaders DownloadVideo() tries and in
+// what order. If one fails the next is tried.
+func (ytm *YTMClient) SetDownloaders(downloaders ...Downloader) {
+	ytm.downloaders = downloaders
+}
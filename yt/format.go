@@ -0,0 +1,146 @@
+package yt
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+var ErrNoAudioFormat = errors.New("no format with audio")
+
+// Quality is a bitrate ceiling for format selection
+type Quality int
+
+const (
+	QualityHigh Quality = iota
+	QualityMedium
+	QualityLow
+)
+
+// Highest bitrate each tier will pick, in bits per second. High has no limit.
+var qualityCeilings = map[Quality]int{
+	QualityHigh:   0,
+	QualityMedium: 160_000,
+	QualityLow:    64_000,
+}
+
+func (q Quality) String() string {
+	switch q {
+	case QualityHigh:
+		return "high"
+	case QualityMedium:
+		return "medium"
+	case QualityLow:
+		return "low"
+	}
+	return ""
+}
+
+func ParseQuality(s string) (Quality, error) {
+	switch strings.ToLower(s) {
+	case "", "high":
+		return QualityHigh, nil
+	case "medium":
+		return QualityMedium, nil
+	case "low":
+		return QualityLow, nil
+	}
+	return QualityHigh, fmt.Errorf("ParseQuality(): unknown quality %q, expected high, medium or low", s)
+}
+
+// Codecs YouTube serves audio-only streams in
+const (
+	CodecOpus = "opus"
+	CodecAAC  = "aac"
+)
+
+// FormatPolicy decides which of a video's formats to download
+type FormatPolicy struct {
+	Quality Quality
+	// Preferred codec, CodecOpus or CodecAAC. Empty takes whichever has the
+	// better bitrate.
+	Codec string
+}
+
+// SelectFormat picks the format to download. Audio-only formats are
+// preferred, the muxed ones carry a video stream we'd throw away. Among
+// those the preferred codec wins, then the highest bitrate under the
+// quality ceiling. If everything is over the ceiling the lowest bitrate is
+// used.
+func SelectFormat(formats youtube.FormatList, policy FormatPolicy) (*youtube.Format, error) {
+	candidates := formats.Select(func(f youtube.Format) bool {
+		return f.AudioChannels > 0 && strings.HasPrefix(f.MimeType, "audio/")
+	})
+	if len(candidates) == 0 {
+		// Smallest video first, it's all wasted bandwidth
+		candidates = formats.WithAudioChannels()
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Width*candidates[i].Height < candidates[j].Width*candidates[j].Height
+		})
+		if len(candidates) == 0 {
+			return nil, ErrNoAudioFormat
+		}
+		return &candidates[0], nil
+	}
+
+	if policy.Codec != "" {
+		preferred := candidates.Select(func(f youtube.Format) bool { return Codec(f.MimeType) == policy.Codec })
+		if len(preferred) > 0 {
+			candidates = preferred
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return bitrate(candidates[i]) > bitrate(candidates[j])
+	})
+	ceiling := qualityCeilings[policy.Quality]
+	for i := range candidates {
+		if ceiling == 0 || bitrate(candidates[i]) <= ceiling {
+			return &candidates[i], nil
+		}
+	}
+	return &candidates[len(candidates)-1], nil
+}
+
+// AverageBitrate is what the file actually works out to, Bitrate is the peak
+func bitrate(f youtube.Format) int {
+	if f.AverageBitrate > 0 {
+		return f.AverageBitrate
+	}
+	return f.Bitrate
+}
+
+// Codec returns CodecOpus or CodecAAC for a mime type like
+// audio/webm; codecs="opus", or the raw codec string for anything else
+func Codec(mimeType string) string {
+	_, codecs, _ := strings.Cut(mimeType, "codecs=")
+	codecs = strings.Trim(codecs, `" `)
+	switch {
+	case codecs == "opus":
+		return CodecOpus
+	case strings.HasPrefix(codecs, "mp4a"):
+		return CodecAAC
+	}
+	return codecs
+}
+
+// Container returns the file extension for a format's mime type
+func Container(mimeType string) string {
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	switch strings.TrimSpace(mediaType) {
+	case "audio/webm", "video/webm":
+		return ".webm"
+	case "audio/mp4":
+		return ".m4a"
+	}
+	return ".mp4"
+}
+
+// SetFormatPolicy changes which formats DownloadVideo() picks. Already
+// cached downloads are kept whatever their quality.
+func (ytm *YTMClient) SetFormatPolicy(policy FormatPolicy) {
+	ytm.formatPolicy = policy
+}
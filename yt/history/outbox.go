@@ -0,0 +1,267 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lordxarus/ytmusic_cli/internal/atomicfile"
+)
+
+const (
+	minBackoff = time.Second * 5
+	maxBackoff = time.Minute * 10
+)
+
+// Reporter is whatever actually tells YouTube Music a song was played.
+// *yt.YTMClient satisfies this.
+type Reporter interface {
+	AddToHistory(videoId string) error
+}
+
+type Entry struct {
+	// Tells entries apart, two reports can be queued in the same instant
+	ID          int64     `json:"id"`
+	VideoId     string    `json:"videoId"`
+	QueuedAt    time.Time `json:"queuedAt"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// Outbox is a persistent queue of history reports. Entries are written to
+// disk as soon as they're added and only removed once the Reporter accepts
+// them, so anything queued while offline survives a restart.
+type Outbox struct {
+	path     string
+	reporter Reporter
+
+	mu      sync.Mutex
+	entries []Entry
+	private bool
+	nextID  int64
+
+	wake chan struct{}
+}
+
+func NewOutbox(path string, reporter Reporter) (*Outbox, error) {
+	o := &Outbox{
+		path:     path,
+		reporter: reporter,
+		wake:     make(chan struct{}, 1),
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("NewOutbox(): failed to read %s: %w", path, err)
+	default:
+		if err = o.decode(data); err != nil {
+			return nil, fmt.Errorf("NewOutbox(): failed to decode %s: %w", path, err)
+		}
+	}
+
+	return o, nil
+}
+
+// outboxFile is what's saved to disk
+type outboxFile struct {
+	Private bool    `json:"private,omitempty"`
+	Entries []Entry `json:"entries"`
+}
+
+// decode reads the outbox file, or the plain list of entries it used to be.
+// Entries from before they had IDs are given one.
+func (o *Outbox) decode(data []byte) error {
+	var file outboxFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		if err = json.Unmarshal(data, &file.Entries); err != nil {
+			return err
+		}
+	}
+	o.private, o.entries = file.Private, file.Entries
+	for _, entry := range o.entries {
+		o.nextID = max(o.nextID, entry.ID)
+	}
+	for i := range o.entries {
+		if o.entries[i].ID == 0 {
+			o.nextID++
+			o.entries[i].ID = o.nextID
+		}
+	}
+	return nil
+}
+
+// Add queues a history report for videoId. It never talks to the network,
+// the worker started by Run does that. In a private session this is a no-op.
+func (o *Outbox) Add(videoId string) error {
+	o.mu.Lock()
+	if o.private {
+		o.mu.Unlock()
+		log.Printf("Outbox.Add(): private session, not reporting %s", videoId)
+		return nil
+	}
+	now := time.Now()
+	o.nextID++
+	o.entries = append(o.entries, Entry{
+		ID:          o.nextID,
+		VideoId:     videoId,
+		QueuedAt:    now,
+		NextAttempt: now,
+	})
+	err := o.save()
+	o.mu.Unlock()
+
+	o.poke()
+	return err
+}
+
+// SetPrivate turns the private session on or off, it stays that way after
+// a restart. Reports already queued before the session went private are
+// still delivered.
+func (o *Outbox) SetPrivate(private bool) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.private = private
+	return o.save()
+}
+
+func (o *Outbox) Private() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.private
+}
+
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Run flushes the outbox until stop is closed. Failed entries are retried
+// with exponential backoff, capped at maxBackoff, and are never dropped.
+func (o *Outbox) Run(stop <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-o.wake:
+		case <-timer.C:
+		}
+
+		next := o.flush()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// flush tries every entry that is due and returns when the earliest
+// remaining entry will next be due, or the zero time if the outbox is empty.
+func (o *Outbox) flush() time.Time {
+	o.mu.Lock()
+	pending := make([]Entry, len(o.entries))
+	copy(pending, o.entries)
+	o.mu.Unlock()
+
+	now := time.Now()
+	done := make(map[int64]bool)
+	failed := make(map[int64]Entry)
+
+	for _, entry := range pending {
+		if entry.NextAttempt.After(now) {
+			continue
+		}
+		err := o.reporter.AddToHistory(entry.VideoId)
+		if err == nil {
+			done[entry.ID] = true
+			continue
+		}
+		entry.Attempts++
+		entry.LastError = err.Error()
+		entry.NextAttempt = time.Now().Add(backoff(entry.Attempts))
+		failed[entry.ID] = entry
+		log.Printf("Outbox.flush(): attempt %d for %s failed: %s", entry.Attempts, entry.VideoId, err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Entries may have been added while we were reporting so rebuild from
+	// the live slice rather than the snapshot.
+	kept := o.entries[:0]
+	for _, entry := range o.entries {
+		if done[entry.ID] {
+			continue
+		}
+		if e, ok := failed[entry.ID]; ok {
+			entry = e
+		}
+		kept = append(kept, entry)
+	}
+	o.entries = kept
+
+	if len(done) > 0 || len(failed) > 0 {
+		if err := o.save(); err != nil {
+			log.Printf("Outbox.flush(): %s", err)
+		}
+	}
+
+	var next time.Time
+	for _, entry := range o.entries {
+		if next.IsZero() || entry.NextAttempt.Before(next) {
+			next = entry.NextAttempt
+		}
+	}
+	return next
+}
+
+func (o *Outbox) poke() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save must be called with mu held
+func (o *Outbox) save() error {
+	data, err := json.MarshalIndent(outboxFile{Private: o.private, Entries: o.entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Outbox.save(): failed to encode: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(o.path), 0o750); err != nil {
+		return fmt.Errorf("Outbox.save(): %w", err)
+	}
+	if err = atomicfile.WriteFile(o.path, data, 0o600); err != nil {
+		return fmt.Errorf("Outbox.save(): failed to write %s: %w", o.path, err)
+	}
+	return nil
+}
+
+func backoff(attempts int) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
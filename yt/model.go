@@ -0,0 +1,434 @@
+package yt
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These are shared by every endpoint. ytmusicapi isn't consistent about
+// field names or types between endpoints (an album is {id, name} on a search
+// result but {browseId, title} on an album result, years are sometimes
+// strings and sometimes ints) so the types that need it decode tolerantly
+// and always encode the same way.
+type (
+	Track struct {
+		VideoId    string        `json:"videoId"`
+		Title      string        `json:"title"`
+		Artists    []Artist      `json:"artists"`
+		Album      Album         `json:"album"`
+		Duration   time.Duration `json:"-"`
+		Year       int           `json:"year,omitempty"`
+		IsExplicit bool          `json:"isExplicit"`
+		InLibrary  bool          `json:"inLibrary"`
+		Category   string        `json:"category,omitempty"`
+		ResultType string        `json:"resultType,omitempty"`
+		VideoType  string        `json:"videoType,omitempty"`
+		// Position on its album, only set on album tracks
+		TrackNumber int `json:"trackNumber,omitempty"`
+		// Only set for uploaded tracks
+		EntityId       string         `json:"entityId,omitempty"`
+		FeedbackTokens FeedbackTokens `json:"feedbackTokens"`
+		Thumbnails     []Thumbnail    `json:"thumbnails"`
+	}
+
+	Album struct {
+		ID         string      `json:"id"`
+		Name       string      `json:"name"`
+		Artists    []Artist    `json:"artists,omitempty"`
+		Year       int         `json:"year,omitempty"`
+		Type       string      `json:"type,omitempty"`
+		PlaylistId string      `json:"playlistId,omitempty"`
+		Thumbnails []Thumbnail `json:"thumbnails,omitempty"`
+	}
+
+	Artist struct {
+		ID         string      `json:"id"`
+		Name       string      `json:"name"`
+		Thumbnails []Thumbnail `json:"thumbnails,omitempty"`
+	}
+
+	Playlist struct {
+		ID          string      `json:"id"`
+		Title       string      `json:"title"`
+		Description string      `json:"description,omitempty"`
+		Author      []Artist    `json:"author,omitempty"`
+		Count       int         `json:"count,omitempty"`
+		Thumbnails  []Thumbnail `json:"thumbnails,omitempty"`
+	}
+
+	Thumbnail struct {
+		Height int    `json:"height"`
+		Width  int    `json:"width"`
+		URL    string `json:"url"`
+	}
+
+	FeedbackTokens struct {
+		Add    string `json:"add"`
+		Remove string `json:"remove"`
+	}
+
+	// Shelf is one titled row of the home page
+	Shelf struct {
+		Title    string `json:"title"`
+		Contents []Item `json:"contents"`
+	}
+
+	// Item is one entry on a shelf, exactly one of the fields is set
+	Item struct {
+		Track    *Track    `json:"track,omitempty"`
+		Album    *Album    `json:"album,omitempty"`
+		Playlist *Playlist `json:"playlist,omitempty"`
+		Artist   *Artist   `json:"artist,omitempty"`
+	}
+)
+
+func (i *Item) UnmarshalJSON(data []byte) error {
+	// ytmusicapi has no type field so go by the IDs
+	var ids struct {
+		VideoId    string `json:"videoId"`
+		BrowseId   string `json:"browseId"`
+		PlaylistId string `json:"playlistId"`
+	}
+	err := json.Unmarshal(data, &ids)
+	if err != nil && !isTypeError(err) {
+		return err
+	}
+
+	*i = Item{}
+	switch {
+	case ids.VideoId != "":
+		i.Track = &Track{}
+		return json.Unmarshal(data, i.Track)
+	case strings.HasPrefix(ids.BrowseId, "UC"):
+		i.Artist = &Artist{}
+		return json.Unmarshal(data, i.Artist)
+	case strings.HasPrefix(ids.BrowseId, "MPRE"):
+		i.Album = &Album{}
+		return json.Unmarshal(data, i.Album)
+	case ids.PlaylistId != "", strings.HasPrefix(ids.BrowseId, "VL"):
+		i.Playlist = &Playlist{}
+		return json.Unmarshal(data, i.Playlist)
+	}
+
+	// Our own encoding
+	type item Item
+	var wrapped item
+	if err = json.Unmarshal(data, &wrapped); err != nil && !isTypeError(err) {
+		return err
+	}
+	if wrapped.Track != nil || wrapped.Album != nil || wrapped.Playlist != nil || wrapped.Artist != nil {
+		*i = Item(wrapped)
+		return err
+	}
+	return fmt.Errorf("Item.UnmarshalJSON(): can't tell what kind of item %s is", ids.BrowseId)
+}
+
+// Title of whatever the item is
+func (i Item) Title() string {
+	switch {
+	case i.Track != nil:
+		return i.Track.Title
+	case i.Album != nil:
+		return i.Album.Name
+	case i.Playlist != nil:
+		return i.Playlist.Title
+	case i.Artist != nil:
+		return i.Artist.Name
+	}
+	return ""
+}
+
+// trackJSON is Track as ytmusicapi sends it. Duration and year come
+// through as raw JSON so they can be parsed leniently.
+type trackJSON struct {
+	VideoId         string          `json:"videoId"`
+	Title           string          `json:"title"`
+	Artists         json.RawMessage `json:"artists"`
+	Album           json.RawMessage `json:"album"`
+	Duration        json.RawMessage `json:"duration"`
+	DurationSeconds json.RawMessage `json:"duration_seconds"`
+	Year            json.RawMessage `json:"year"`
+	TrackNumber     json.RawMessage `json:"trackNumber"`
+	IsExplicit      bool            `json:"isExplicit"`
+	InLibrary       bool            `json:"inLibrary"`
+	Category        string          `json:"category"`
+	ResultType      string          `json:"resultType"`
+	VideoType       string          `json:"videoType"`
+	EntityId        string          `json:"entityId"`
+	FeedbackTokens  *FeedbackTokens `json:"feedbackTokens"`
+	Thumbnails      []Thumbnail     `json:"thumbnails"`
+}
+
+func (t *Track) UnmarshalJSON(data []byte) error {
+	var raw trackJSON
+	// A field of the wrong type is left empty, the rest is still decoded
+	err := json.Unmarshal(data, &raw)
+	if err != nil && !isTypeError(err) {
+		return err
+	}
+
+	artists, artistsErr := decodeFieldList[Artist](raw.Artists, "artists")
+	album, albumErr := decodeField[Album](raw.Album, "album")
+
+	*t = Track{
+		VideoId:    raw.VideoId,
+		Title:      raw.Title,
+		Artists:    artists,
+		Album:      album,
+		Duration:   parseDuration(raw.DurationSeconds, time.Second),
+		Year:       parseYear(raw.Year),
+		IsExplicit: raw.IsExplicit,
+		InLibrary:  raw.InLibrary,
+		Category:   raw.Category,
+		ResultType: raw.ResultType,
+		VideoType:  raw.VideoType,
+		EntityId:   raw.EntityId,
+		Thumbnails: raw.Thumbnails,
+	}
+	t.TrackNumber = parseCount(raw.TrackNumber)
+	if t.Duration == 0 {
+		t.Duration = parseDuration(raw.Duration, time.Second)
+	}
+	if raw.FeedbackTokens != nil {
+		t.FeedbackTokens = *raw.FeedbackTokens
+	}
+	return firstError(err, artistsErr, albumErr)
+}
+
+// MarshalJSON writes the duration the way ytmusicapi does so the output
+// decodes back into the same Track
+func (t Track) MarshalJSON() ([]byte, error) {
+	type track Track
+	return json.Marshal(struct {
+		track
+		Duration        string `json:"duration"`
+		DurationSeconds int    `json:"duration_seconds"`
+	}{
+		track:           track(t),
+		Duration:        FormatDuration(t.Duration),
+		DurationSeconds: int(t.Duration.Seconds()),
+	})
+}
+
+func (a *Album) UnmarshalJSON(data []byte) error {
+	// get_album's tracks only carry the album's name
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		*a = Album{Name: name}
+		return nil
+	}
+
+	var raw struct {
+		ID         string          `json:"id"`
+		BrowseId   string          `json:"browseId"`
+		Name       string          `json:"name"`
+		Title      string          `json:"title"`
+		Artists    json.RawMessage `json:"artists"`
+		Year       json.RawMessage `json:"year"`
+		Type       string          `json:"type"`
+		PlaylistId string          `json:"playlistId"`
+		// get_album calls it this
+		AudioPlaylistId string      `json:"audioPlaylistId"`
+		Thumbnails      []Thumbnail `json:"thumbnails"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil && !isTypeError(err) {
+		return err
+	}
+
+	artists, artistsErr := decodeFieldList[Artist](raw.Artists, "artists")
+
+	*a = Album{
+		ID:         firstNonEmpty(raw.ID, raw.BrowseId),
+		Name:       firstNonEmpty(raw.Name, raw.Title),
+		Artists:    artists,
+		Year:       parseYear(raw.Year),
+		Type:       raw.Type,
+		PlaylistId: firstNonEmpty(raw.PlaylistId, raw.AudioPlaylistId),
+		Thumbnails: raw.Thumbnails,
+	}
+	return firstError(err, artistsErr)
+}
+
+func (a *Artist) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ID         string      `json:"id"`
+		BrowseId   string      `json:"browseId"`
+		Name       string      `json:"name"`
+		Artist     string      `json:"artist"`
+		Title      string      `json:"title"`
+		Thumbnails []Thumbnail `json:"thumbnails"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil && !isTypeError(err) {
+		return err
+	}
+
+	*a = Artist{
+		ID:         firstNonEmpty(raw.ID, raw.BrowseId),
+		Name:       firstNonEmpty(raw.Name, raw.Artist, raw.Title),
+		Thumbnails: raw.Thumbnails,
+	}
+	return err
+}
+
+func (p *Playlist) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ID          string          `json:"id"`
+		PlaylistId  string          `json:"playlistId"`
+		BrowseId    string          `json:"browseId"`
+		Title       string          `json:"title"`
+		Description string          `json:"description"`
+		Author      json.RawMessage `json:"author"`
+		Count       json.RawMessage `json:"count"`
+		ItemCount   json.RawMessage `json:"itemCount"`
+		Thumbnails  []Thumbnail     `json:"thumbnails"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil && !isTypeError(err) {
+		return err
+	}
+
+	*p = Playlist{
+		ID:          firstNonEmpty(raw.ID, raw.PlaylistId, raw.BrowseId),
+		Title:       raw.Title,
+		Description: raw.Description,
+		Count:       parseCount(raw.Count),
+		Thumbnails:  raw.Thumbnails,
+	}
+	if p.Count == 0 {
+		p.Count = parseCount(raw.ItemCount)
+	}
+
+	// author is a list of artists on most endpoints but a single one on some
+	if len(raw.Author) > 0 && json.Unmarshal(raw.Author, &p.Author) != nil {
+		var author Artist
+		if json.Unmarshal(raw.Author, &author) == nil {
+			p.Author = []Artist{author}
+		}
+	}
+	return err
+}
+
+// Names is a comma separated list of the artists' names
+func Names(artists []Artist) string {
+	names := make([]string, 0, len(artists))
+	for _, artist := range artists {
+		names = append(names, artist.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// FormatDuration formats d like YouTube Music does, 3:05 or 1:02:03
+func FormatDuration(d time.Duration) string {
+	secs := int(d.Round(time.Second).Seconds())
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+var (
+	clockRe = regexp.MustCompile(`^(\d+:)?\d+:\d+$`)
+	wordsRe = regexp.MustCompile(`(\d+)\s*(hr|hour|min|sec|h|m|s)`)
+)
+
+// parseDuration accepts a number of units, "3:05", "1:02:03" or
+// "1 hr 3 min" and returns 0 for anything else
+func parseDuration(raw json.RawMessage, unit time.Duration) time.Duration {
+	if len(raw) == 0 {
+		return 0
+	}
+
+	var n float64
+	if json.Unmarshal(raw, &n) == nil {
+		return time.Duration(n * float64(unit))
+	}
+
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return 0
+	}
+	s = strings.TrimSpace(s)
+
+	if clockRe.MatchString(s) {
+		var d time.Duration
+		for _, part := range strings.Split(s, ":") {
+			v, _ := strconv.Atoi(part)
+			d = d*60 + time.Duration(v)*time.Second
+		}
+		return d
+	}
+
+	var d time.Duration
+	for _, match := range wordsRe.FindAllStringSubmatch(s, -1) {
+		v, _ := strconv.Atoi(match[1])
+		switch match[2] {
+		case "hr", "hour", "h":
+			d += time.Duration(v) * time.Hour
+		case "min", "m":
+			d += time.Duration(v) * time.Minute
+		case "sec", "s":
+			d += time.Duration(v) * time.Second
+		}
+	}
+	return d
+}
+
+var yearRe = regexp.MustCompile(`\b(1[89]|20)\d\d\b`)
+
+// parseYear takes 2019 or "2019" and returns 0 for anything that
+// doesn't look like a year
+func parseYear(raw json.RawMessage) int {
+	if len(raw) == 0 {
+		return 0
+	}
+
+	var n int
+	if json.Unmarshal(raw, &n) == nil {
+		return n
+	}
+
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return 0
+	}
+	n, _ = strconv.Atoi(yearRe.FindString(s))
+	return n
+}
+
+// parseCount takes 12, "12" or "1,234 songs"
+func parseCount(raw json.RawMessage) int {
+	if len(raw) == 0 {
+		return 0
+	}
+
+	var n int
+	if json.Unmarshal(raw, &n) == nil {
+		return n
+	}
+
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return 0
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0
+	}
+	n, _ = strconv.Atoi(strings.ReplaceAll(fields[0], ",", ""))
+	return n
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,213 @@
+package yt
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lordxarus/ytmusic_cli/yt/search"
+)
+
+// Saved episodes live in a special playlist
+const savedEpisodesPlaylist = "SE"
+
+type (
+	Podcast struct {
+		// BrowseId from search results, pass it to GetPodcast()
+		BrowseId    string      `json:"browseId,omitempty"`
+		PlaylistId  string      `json:"playlistId,omitempty"`
+		Title       string      `json:"title"`
+		Author      Artist      `json:"author"`
+		Description string      `json:"description,omitempty"`
+		Saved       bool        `json:"saved"`
+		Thumbnails  []Thumbnail `json:"thumbnails,omitempty"`
+		Episodes    []Episode   `json:"episodes,omitempty"`
+	}
+
+	Episode struct {
+		VideoId     string        `json:"videoId"`
+		BrowseId    string        `json:"browseId,omitempty"`
+		Index       int           `json:"index"`
+		Title       string        `json:"title"`
+		Description string        `json:"description,omitempty"`
+		Duration    time.Duration `json:"-"`
+		Date        string        `json:"date,omitempty"`
+		Podcast     Album         `json:"podcast"`
+		Thumbnails  []Thumbnail   `json:"thumbnails,omitempty"`
+	}
+)
+
+// Episode durations come through as "1 hr 3 min" or "36 min"
+func (e *Episode) UnmarshalJSON(data []byte) error {
+	type episode Episode
+	var raw struct {
+		episode
+		Duration json.RawMessage `json:"duration"`
+		Podcast  json.RawMessage `json:"podcast"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil && !isTypeError(err) {
+		return err
+	}
+	podcast, podcastErr := decodeField[Album](raw.Podcast, "podcast")
+
+	*e = Episode(raw.episode)
+	e.Duration = parseDuration(raw.Duration, time.Second)
+	e.Podcast = podcast
+	return firstError(err, podcastErr)
+}
+
+func (e Episode) MarshalJSON() ([]byte, error) {
+	type episode Episode
+	return json.Marshal(struct {
+		episode
+		Duration string `json:"duration"`
+	}{episode(e), FormatDuration(e.Duration)})
+}
+
+// Track lets an episode go through the same playback path as a song
+func (e Episode) Track() Track {
+	return Track{
+		Album:      e.Podcast,
+		Title:      e.Title,
+		VideoId:    e.VideoId,
+		Duration:   e.Duration,
+		ResultType: "episode",
+		Thumbnails: e.Thumbnails,
+	}
+}
+
+func (ytm *YTMClient) SearchPodcasts(query string) ([]Podcast, error) {
+	var podcasts []Podcast
+	if err := searchInto(ytm, query, search.Podcasts, &podcasts); err != nil {
+		return nil, fmt.Errorf("SearchPodcasts(): %w", err)
+	}
+	return podcasts, nil
+}
+
+// GetPodcast fetches a podcast and its episodes. id is either the browse ID
+// from a search result or the podcast's playlist ID.
+func (ytm *YTMClient) GetPodcast(id string) (Podcast, error) {
+	var podcast Podcast
+
+	result, err := ytm.runPyScript(fmt.Sprintf("ytmusic.get_podcast(%s)", pyString(playlistIdFromBrowseId(id))))
+	if err != nil {
+		return podcast, fmt.Errorf("GetPodcast(): %w", err)
+	}
+	// Decode the episodes one by one so a bad one doesn't lose the rest
+	var raw struct {
+		Podcast
+		Episodes json.RawMessage `json:"episodes"`
+	}
+	d := newDecoder("get_podcast")
+	defer ytm.record(d)
+	if err = json.Unmarshal([]byte(result), &raw); err != nil {
+		if !isTypeError(err) {
+			return podcast, fmt.Errorf("GetPodcast(): unable to unmarshal JSON: %w", err)
+		}
+		d.warn("$", err, false)
+	}
+	podcast = raw.Podcast
+	if podcast.Episodes, err = decodeList[Episode](d, raw.Episodes, "$.episodes"); err != nil {
+		return podcast, fmt.Errorf("GetPodcast(): %w", err)
+	}
+
+	podcast.PlaylistId = playlistIdFromBrowseId(id)
+	for i := range podcast.Episodes {
+		podcast.Episodes[i].Podcast = Album{ID: podcast.PlaylistId, Name: podcast.Title}
+	}
+	return podcast, nil
+}
+
+func (ytm *YTMClient) GetEpisode(videoId string) (Episode, error) {
+	var episode Episode
+
+	result, err := ytm.runPyScript(fmt.Sprintf("ytmusic.get_episode(%s)", pyString(videoId)))
+	if err != nil {
+		return episode, fmt.Errorf("GetEpisode(): %w", err)
+	}
+
+	// get_episode nests the podcast under author and the description is
+	// a list of runs rather than a string
+	var raw struct {
+		Title      string          `json:"title"`
+		Duration   json.RawMessage `json:"duration"`
+		Date       string          `json:"date"`
+		PlaylistId string          `json:"playlistId"`
+		Author     Artist          `json:"author"`
+		Thumbnails []Thumbnail     `json:"thumbnails"`
+	}
+	if err = json.Unmarshal([]byte(result), &raw); err != nil {
+		return episode, fmt.Errorf("GetEpisode(): unable to unmarshal JSON: %w", err)
+	}
+
+	episode = Episode{
+		VideoId:    videoId,
+		Title:      raw.Title,
+		Duration:   parseDuration(raw.Duration, time.Second),
+		Date:       raw.Date,
+		Podcast:    Album{ID: raw.PlaylistId, Name: raw.Author.Name},
+		Thumbnails: raw.Thumbnails,
+	}
+	return episode, nil
+}
+
+// SavedEpisodes lists the episodes in the library's "Saved episodes" playlist
+func (ytm *YTMClient) SavedEpisodes() ([]Episode, error) {
+	result, err := ytm.runPyScript(fmt.Sprintf("ytmusic.get_playlist(%s, limit=None)", pyString(savedEpisodesPlaylist)))
+	if err != nil {
+		return nil, fmt.Errorf("SavedEpisodes(): %w", err)
+	}
+
+	var playlist struct {
+		Tracks json.RawMessage `json:"tracks"`
+	}
+	if err = json.Unmarshal([]byte(result), &playlist); err != nil {
+		return nil, fmt.Errorf("SavedEpisodes(): unable to unmarshal JSON: %w", err)
+	}
+	d := newDecoder("get_playlist")
+	defer ytm.record(d)
+	tracks, err := decodeList[Track](d, playlist.Tracks, "$.tracks")
+	if err != nil {
+		return nil, fmt.Errorf("SavedEpisodes(): %w", err)
+	}
+
+	episodes := make([]Episode, 0, len(tracks))
+	for i, track := range tracks {
+		episodes = append(episodes, Episode{
+			VideoId:    track.VideoId,
+			Index:      i,
+			Title:      track.Title,
+			Duration:   track.Duration,
+			Podcast:    track.Album,
+			Thumbnails: track.Thumbnails,
+		})
+	}
+	return episodes, nil
+}
+
+func (ytm *YTMClient) SaveEpisode(videoId string) error {
+	_, err := ytm.runPyScript(fmt.Sprintf("ytmusic.add_playlist_items(%s, [%s])", pyString(savedEpisodesPlaylist), pyString(videoId)))
+	if err != nil {
+		return fmt.Errorf("SaveEpisode(): %w", err)
+	}
+	return nil
+}
+
+func (ytm *YTMClient) UnsaveEpisode(videoId string) error {
+	_, err := ytm.runPyScript(fmt.Sprintf(
+		"ytmusic.remove_playlist_items(%[1]s, [t for t in ytmusic.get_playlist(%[1]s, limit=None)['tracks'] if t['videoId'] == %[2]s])",
+		pyString(savedEpisodesPlaylist), pyString(videoId)))
+	if err != nil {
+		return fmt.Errorf("UnsaveEpisode(): %w", err)
+	}
+	return nil
+}
+
+// Podcast browse IDs are the playlist ID with an MPSP prefix
+func playlistIdFromBrowseId(id string) string {
+	if len(id) > 4 && id[:4] == "MPSP" {
+		return id[4:]
+	}
+	return id
+}
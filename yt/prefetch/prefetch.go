@@ -0,0 +1,177 @@
+// Package prefetch downloads the tracks coming up next so they're cached by
+// the time they're played.
+package prefetch
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Fetch downloads videoId into the cache. It should return nil if it's
+// already there and give up when ctx is cancelled.
+type Fetch func(ctx context.Context, videoId string) error
+
+// Stats are counted by Played()
+type Stats struct {
+	// Played tracks that had been prefetched
+	Hits int `json:"hits"`
+	// Played tracks that were still being prefetched
+	Late int `json:"late"`
+	// Played tracks that weren't prefetched at all
+	Misses int `json:"misses"`
+	// Prefetches finished, failed and cancelled because the list changed
+	Fetched   int `json:"fetched"`
+	Failed    int `json:"failed"`
+	Cancelled int `json:"cancelled"`
+}
+
+type job struct {
+	videoId string
+	cancel  context.CancelFunc
+}
+
+// Prefetcher runs up to a fixed number of downloads at once, in the order
+// of the list given to Set()
+type Prefetcher struct {
+	fetch   Fetch
+	workers int
+
+	mu sync.Mutex
+	// What Set() was last given, most urgent first
+	upcoming []string
+	running  map[string]*job
+	fetched  map[string]bool
+	failed   map[string]bool
+	stats    Stats
+}
+
+func New(fetch Fetch, workers int) *Prefetcher {
+	return &Prefetcher{
+		fetch:   fetch,
+		workers: max(workers, 1),
+		running: make(map[string]*job),
+		fetched: make(map[string]bool),
+		failed:  make(map[string]bool),
+	}
+}
+
+// Set replaces the tracks to prefetch, most urgent first. Prefetches of
+// tracks that aren't in videoIds any more are cancelled, what they
+// downloaded is kept for next time.
+func (p *Prefetcher) Set(videoIds []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wanted := make(map[string]bool, len(videoIds))
+	for _, id := range videoIds {
+		wanted[id] = true
+	}
+	for id, j := range p.running {
+		if !wanted[id] {
+			log.Printf("Prefetcher.Set(): cancelling stale prefetch of %s", id)
+			j.cancel()
+			delete(p.running, id)
+			p.stats.Cancelled++
+		}
+	}
+	for id := range p.fetched {
+		if !wanted[id] {
+			delete(p.fetched, id)
+		}
+	}
+	// A new list is a new chance for anything that failed
+	p.failed = make(map[string]bool)
+
+	p.upcoming = append([]string(nil), videoIds...)
+	p.schedule()
+}
+
+// Stop cancels everything
+func (p *Prefetcher) Stop() {
+	p.Set(nil)
+}
+
+// Played records whether videoId was ready when it was played, see Stats
+func (p *Prefetcher) Played(videoId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.fetched[videoId]:
+		p.stats.Hits++
+		delete(p.fetched, videoId)
+	case p.running[videoId] != nil:
+		p.stats.Late++
+	default:
+		p.stats.Misses++
+	}
+}
+
+func (p *Prefetcher) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// schedule starts jobs for the most urgent tracks that aren't done yet. If
+// every worker is busy and the most urgent track isn't one of them, the
+// least urgent job is put back in the queue to make room. Must be called
+// with mu held.
+func (p *Prefetcher) schedule() {
+	for rank, id := range p.upcoming {
+		if p.fetched[id] || p.failed[id] || p.running[id] != nil {
+			continue
+		}
+		if len(p.running) >= p.workers {
+			if rank != 0 {
+				return
+			}
+			p.preemptLeastUrgent()
+		}
+		p.start(id)
+	}
+}
+
+// preemptLeastUrgent must be called with mu held
+func (p *Prefetcher) preemptLeastUrgent() {
+	for i := len(p.upcoming) - 1; i >= 0; i-- {
+		if j := p.running[p.upcoming[i]]; j != nil {
+			log.Printf("Prefetcher: pausing %s for something more urgent", j.videoId)
+			j.cancel()
+			delete(p.running, j.videoId)
+			return
+		}
+	}
+}
+
+// start must be called with mu held
+func (p *Prefetcher) start(videoId string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{videoId: videoId, cancel: cancel}
+	p.running[videoId] = j
+
+	go func() {
+		err := p.fetch(ctx, videoId)
+		cancel()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		// Set() and preemptLeastUrgent() take cancelled jobs out of running
+		cancelled := p.running[videoId] != j
+		if !cancelled {
+			delete(p.running, videoId)
+		}
+		switch {
+		case cancelled:
+		case err != nil:
+			log.Printf("Prefetcher: failed to prefetch %s: %s", videoId, err)
+			p.failed[videoId] = true
+			p.stats.Failed++
+		default:
+			p.fetched[videoId] = true
+			p.stats.Fetched++
+		}
+		p.schedule()
+	}()
+}
@@ -0,0 +1,566 @@
+// Package queue is the list of tracks the player works through, with
+// shuffle, repeat and a history for going back.
+package queue
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+
+	"github.com/lordxarus/ytmusic_cli/yt"
+)
+
+// Repeat is what happens at the end of a track or of the queue
+type Repeat int
+
+const (
+	RepeatOff Repeat = iota
+	// Play the current track again when it finishes
+	RepeatOne
+	// Go back to the start after the last track
+	RepeatAll
+)
+
+func (r Repeat) String() string {
+	switch r {
+	case RepeatOff:
+		return "off"
+	case RepeatOne:
+		return "one"
+	case RepeatAll:
+		return "all"
+	}
+	return ""
+}
+
+func ParseRepeat(s string) (Repeat, error) {
+	switch strings.ToLower(s) {
+	case "", "off":
+		return RepeatOff, nil
+	case "one":
+		return RepeatOne, nil
+	case "all":
+		return RepeatAll, nil
+	}
+	return RepeatOff, fmt.Errorf("ParseRepeat(): unknown repeat mode %q, expected off, one or all", s)
+}
+
+// Entry is one thing in the queue. The same track can be queued more than
+// once, ID tells them apart.
+type Entry struct {
+	ID    int
+	Track yt.Track
+	// Only set when the track is a podcast episode
+	Episode *yt.Episode
+}
+
+// EventKind says what changed
+type EventKind int
+
+const (
+	// Entries were added, removed or moved
+	EntriesChanged EventKind = iota
+	// A different entry is current
+	CurrentChanged
+	// Shuffle or repeat was changed
+	ModeChanged
+)
+
+type Event struct {
+	Kind EventKind
+}
+
+// Queue is safe for concurrent use. Subscribers are called after the
+// change is made, without the queue locked, so they can read it.
+type Queue struct {
+	mu sync.Mutex
+	// In play order, shuffled when shuffle is on
+	entries []Entry
+	// Index into entries, -1 when nothing is current
+	current int
+	// Where to carry on from while nothing is current, the index of what
+	// came after the current entry when it was removed
+	resumeAt int
+	// IDs of entries played before the current one, oldest first
+	history []int
+	// IDs in the order they were queued, only kept while shuffled so
+	// turning it off puts things back
+	unshuffled []int
+	shuffle    bool
+	repeat     Repeat
+	nextID     int
+
+	subsMu      sync.Mutex
+	subscribers map[int]func(Event)
+	nextSub     int
+}
+
+// Don't let history grow forever
+const maxHistory = 500
+
+func New() *Queue {
+	return &Queue{current: -1, subscribers: make(map[int]func(Event))}
+}
+
+// Subscribe calls f with every change until the returned func is called
+func (q *Queue) Subscribe(f func(Event)) (unsubscribe func()) {
+	q.subsMu.Lock()
+	defer q.subsMu.Unlock()
+	id := q.nextSub
+	q.nextSub++
+	q.subscribers[id] = f
+	return func() {
+		q.subsMu.Lock()
+		delete(q.subscribers, id)
+		q.subsMu.Unlock()
+	}
+}
+
+func (q *Queue) emit(kinds ...EventKind) {
+	q.subsMu.Lock()
+	subscribers := make([]func(Event), 0, len(q.subscribers))
+	for _, f := range q.subscribers {
+		subscribers = append(subscribers, f)
+	}
+	q.subsMu.Unlock()
+
+	for _, kind := range kinds {
+		for _, f := range subscribers {
+			f(Event{Kind: kind})
+		}
+	}
+}
+
+// Entries returns the queue in play order
+func (q *Queue) Entries() []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := make([]Entry, len(q.entries))
+	copy(entries, q.entries)
+	return entries
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Current returns the current entry and its index
+func (q *Queue) Current() (Entry, int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.current < 0 {
+		return Entry{}, -1, false
+	}
+	return q.entries[q.current], q.current, true
+}
+
+// Upcoming returns up to n entries that will play after the current one,
+// following repeat all around the end
+func (q *Queue) Upcoming(n int) []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var upcoming []Entry
+	start := q.after()
+	for k := 0; k < len(q.entries) && len(upcoming) < n; k++ {
+		i := start + k
+		if i >= len(q.entries) {
+			if q.repeat != RepeatAll {
+				break
+			}
+			i -= len(q.entries)
+		}
+		if i == q.current {
+			break
+		}
+		upcoming = append(upcoming, q.entries[i])
+	}
+	return upcoming
+}
+
+func (q *Queue) Shuffle() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.shuffle
+}
+
+func (q *Queue) Repeat() Repeat {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.repeat
+}
+
+func (q *Queue) SetRepeat(repeat Repeat) {
+	q.mu.Lock()
+	q.repeat = repeat
+	q.mu.Unlock()
+	q.emit(ModeChanged)
+}
+
+// SetShuffle shuffles everything but the current entry, which moves to the
+// front. Turning it off goes back to the order things were queued in.
+func (q *Queue) SetShuffle(shuffle bool) {
+	q.mu.Lock()
+	if shuffle == q.shuffle {
+		q.mu.Unlock()
+		return
+	}
+	q.shuffle = shuffle
+	if shuffle {
+		q.shuffleEntries()
+	} else {
+		q.unshuffleEntries()
+	}
+	q.mu.Unlock()
+	q.emit(ModeChanged, EntriesChanged)
+}
+
+// shuffleEntries remembers the order and shuffles. Called with mu held.
+func (q *Queue) shuffleEntries() {
+	q.unshuffled = make([]int, len(q.entries))
+	for i, entry := range q.entries {
+		q.unshuffled[i] = entry.ID
+	}
+	if q.current > 0 {
+		q.entries[0], q.entries[q.current] = q.entries[q.current], q.entries[0]
+		q.current = 0
+	}
+	q.resumeAt = 0
+	rest := q.entries[max(q.current+1, 0):]
+	rand.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+}
+
+// unshuffleEntries puts back the order from before shuffleEntries().
+// Called with mu held.
+func (q *Queue) unshuffleEntries() {
+	currentID, resumeID := q.currentID(), q.resumeID()
+	byID := make(map[int]Entry, len(q.entries))
+	for _, entry := range q.entries {
+		byID[entry.ID] = entry
+	}
+	q.entries = q.entries[:0]
+	for _, id := range q.unshuffled {
+		if entry, ok := byID[id]; ok {
+			q.entries = append(q.entries, entry)
+		}
+	}
+	q.unshuffled = nil
+	q.current = q.indexOf(currentID)
+	q.restoreResume(resumeID)
+}
+
+// Set replaces the queue with entries and makes entries[start] current,
+// e.g. when a track is picked from a list. With shuffle on the rest are
+// shuffled around it.
+func (q *Queue) Set(entries []Entry, start int) (Entry, bool) {
+	q.mu.Lock()
+	q.entries = nil
+	q.history = nil
+	q.unshuffled = nil
+	q.current = -1
+	q.resumeAt = 0
+	// add() keeps unshuffled up to date, there's nothing to keep yet
+	shuffle := q.shuffle
+	q.shuffle = false
+	q.add(0, entries)
+	q.shuffle = shuffle
+	if start >= 0 && start < len(q.entries) {
+		q.current = start
+	}
+	if shuffle {
+		q.shuffleEntries()
+	}
+	var current Entry
+	ok := q.current >= 0
+	if ok {
+		current = q.entries[q.current]
+	}
+	q.mu.Unlock()
+	q.emit(EntriesChanged, CurrentChanged)
+	return current, ok
+}
+
+// Enqueue adds entries to the end
+func (q *Queue) Enqueue(entries ...Entry) {
+	q.mu.Lock()
+	q.add(len(q.entries), entries)
+	q.mu.Unlock()
+	q.emit(EntriesChanged)
+}
+
+// PlayNext adds entries straight after the current one
+func (q *Queue) PlayNext(entries ...Entry) {
+	q.mu.Lock()
+	q.add(q.after(), entries)
+	q.mu.Unlock()
+	q.emit(EntriesChanged)
+}
+
+// add inserts entries at i, giving them IDs. Called with mu held.
+func (q *Queue) add(i int, entries []Entry) {
+	atEnd := i == len(q.entries)
+	added := make([]Entry, len(entries))
+	for j, entry := range entries {
+		entry.ID = q.nextID
+		q.nextID++
+		added[j] = entry
+	}
+	q.entries = append(q.entries[:i], append(added, q.entries[i:]...)...)
+	if q.current >= i {
+		q.current += len(added)
+	}
+	// Added where we carry on from they're played first
+	if q.current < 0 && q.resumeAt > i {
+		q.resumeAt += len(added)
+	}
+
+	if q.shuffle {
+		// Unshuffled they go at the end too, or after the same entry
+		at := len(q.unshuffled)
+		if !atEnd && i > 0 {
+			at = indexInt(q.unshuffled, q.entries[i-1].ID) + 1
+		}
+		ids := make([]int, len(added))
+		for j, entry := range added {
+			ids[j] = entry.ID
+		}
+		q.unshuffled = append(q.unshuffled[:at], append(ids, q.unshuffled[at:]...)...)
+	}
+}
+
+// Remove takes out the entry at i. Removing the current entry leaves
+// nothing current, the player carries on until the track ends and then
+// with what came after it.
+func (q *Queue) Remove(i int) bool {
+	q.mu.Lock()
+	if i < 0 || i >= len(q.entries) {
+		q.mu.Unlock()
+		return false
+	}
+	id := q.entries[i].ID
+	q.entries = append(q.entries[:i], q.entries[i+1:]...)
+	currentChanged := false
+	switch {
+	case i == q.current:
+		q.current = -1
+		q.resumeAt = i
+		currentChanged = true
+	case i < q.current:
+		q.current--
+	case q.current < 0 && i < q.resumeAt:
+		q.resumeAt--
+	}
+	if at := indexInt(q.unshuffled, id); at >= 0 {
+		q.unshuffled = append(q.unshuffled[:at], q.unshuffled[at+1:]...)
+	}
+	q.mu.Unlock()
+
+	if currentChanged {
+		q.emit(EntriesChanged, CurrentChanged)
+	} else {
+		q.emit(EntriesChanged)
+	}
+	return true
+}
+
+// Move moves the entry at from to to, the current entry stays current
+func (q *Queue) Move(from int, to int) bool {
+	q.mu.Lock()
+	if from < 0 || from >= len(q.entries) || to < 0 || to >= len(q.entries) {
+		q.mu.Unlock()
+		return false
+	}
+	currentID, resumeID := q.currentID(), q.resumeID()
+	entry := q.entries[from]
+	q.entries = append(q.entries[:from], q.entries[from+1:]...)
+	q.entries = append(q.entries[:to], append([]Entry{entry}, q.entries[to:]...)...)
+	q.current = q.indexOf(currentID)
+	q.restoreResume(resumeID)
+	if q.shuffle {
+		// Moving by hand is a statement about the order, keep it
+		q.unshuffled = q.unshuffled[:0]
+		for _, entry := range q.entries {
+			q.unshuffled = append(q.unshuffled, entry.ID)
+		}
+	}
+	q.mu.Unlock()
+	q.emit(EntriesChanged)
+	return true
+}
+
+// Clear empties the queue and its history
+func (q *Queue) Clear() {
+	q.mu.Lock()
+	q.entries = nil
+	q.unshuffled = nil
+	q.history = nil
+	q.current = -1
+	q.resumeAt = 0
+	q.mu.Unlock()
+	q.emit(EntriesChanged, CurrentChanged)
+}
+
+// Jump makes the entry at i current
+func (q *Queue) Jump(i int) (Entry, bool) {
+	q.mu.Lock()
+	if i < 0 || i >= len(q.entries) {
+		q.mu.Unlock()
+		return Entry{}, false
+	}
+	q.moveTo(i)
+	entry := q.entries[i]
+	q.mu.Unlock()
+	q.emit(CurrentChanged)
+	return entry, true
+}
+
+// Next moves on to the next entry when the user skips. Repeat one doesn't
+// hold it back, repeat all goes round from the end.
+func (q *Queue) Next() (Entry, bool) {
+	return q.advance(false)
+}
+
+// Finished moves on when the current track has played to the end, repeat
+// one plays it again
+func (q *Queue) Finished() (Entry, bool) {
+	return q.advance(true)
+}
+
+func (q *Queue) advance(finished bool) (Entry, bool) {
+	q.mu.Lock()
+	if len(q.entries) == 0 {
+		q.mu.Unlock()
+		return Entry{}, false
+	}
+
+	next := q.after()
+	switch {
+	case finished && q.repeat == RepeatOne && q.current >= 0:
+		next = q.current
+	case next >= len(q.entries) && q.repeat == RepeatAll:
+		next = 0
+	case next >= len(q.entries):
+		q.mu.Unlock()
+		return Entry{}, false
+	}
+	q.moveTo(next)
+	entry := q.entries[next]
+	q.mu.Unlock()
+	q.emit(CurrentChanged)
+	return entry, true
+}
+
+// Following returns what Finished() would move to, without moving
+func (q *Queue) Following() (Entry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	next := q.after()
+	switch {
+	case len(q.entries) == 0:
+		return Entry{}, false
+	case q.repeat == RepeatOne && q.current >= 0:
+		next = q.current
+	case next >= len(q.entries) && q.repeat == RepeatAll:
+		next = 0
+	case next >= len(q.entries):
+		return Entry{}, false
+	}
+	return q.entries[next], true
+}
+
+// Previous goes back to what was playing before the current entry, or the
+// entry before it if there's no history left
+func (q *Queue) Previous() (Entry, bool) {
+	q.mu.Lock()
+	previous := -1
+	for len(q.history) > 0 && previous < 0 {
+		id := q.history[len(q.history)-1]
+		q.history = q.history[:len(q.history)-1]
+		previous = q.indexOf(id)
+	}
+	if previous < 0 {
+		switch {
+		case q.current > 0:
+			previous = q.current - 1
+		case len(q.entries) > 0 && q.repeat == RepeatAll:
+			previous = len(q.entries) - 1
+		default:
+			q.mu.Unlock()
+			return Entry{}, false
+		}
+	}
+	q.current = previous
+	entry := q.entries[previous]
+	q.mu.Unlock()
+	q.emit(CurrentChanged)
+	return entry, true
+}
+
+// moveTo makes i current and remembers what was. Called with mu held.
+func (q *Queue) moveTo(i int) {
+	if q.current >= 0 && q.current != i {
+		q.history = append(q.history, q.entries[q.current].ID)
+		if over := len(q.history) - maxHistory; over > 0 {
+			q.history = q.history[over:]
+		}
+	}
+	q.current = i
+}
+
+// after is the index of the entry after the current one, or where to carry
+// on from when nothing is. Called with mu held.
+func (q *Queue) after() int {
+	if q.current >= 0 {
+		return q.current + 1
+	}
+	return q.resumeAt
+}
+
+// resumeID and restoreResume keep resumeAt on the same entry while the
+// entries are rearranged. Called with mu held.
+func (q *Queue) resumeID() int {
+	if q.current >= 0 || q.resumeAt >= len(q.entries) {
+		return -1
+	}
+	return q.entries[q.resumeAt].ID
+}
+
+func (q *Queue) restoreResume(id int) {
+	if i := q.indexOf(id); i >= 0 {
+		q.resumeAt = i
+	} else if q.resumeAt > len(q.entries) {
+		q.resumeAt = len(q.entries)
+	}
+}
+
+// Called with mu held
+func (q *Queue) currentID() int {
+	if q.current < 0 {
+		return -1
+	}
+	return q.entries[q.current].ID
+}
+
+// Called with mu held
+func (q *Queue) indexOf(id int) int {
+	if id < 0 {
+		return -1
+	}
+	for i, entry := range q.entries {
+		if entry.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexInt(ids []int, id int) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
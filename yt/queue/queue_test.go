@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/lordxarus/ytmusic_cli/yt"
+)
+
+func entries(ids ...string) []Entry {
+	entries := make([]Entry, len(ids))
+	for i, id := range ids {
+		entries[i] = Entry{Track: yt.Track{VideoId: id}}
+	}
+	return entries
+}
+
+func videoIds(entries []Entry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.Track.VideoId
+	}
+	return ids
+}
+
+func current(t *testing.T, q *Queue) string {
+	t.Helper()
+	entry, _, ok := q.Current()
+	if !ok {
+		t.Fatal("nothing is current")
+	}
+	return entry.Track.VideoId
+}
+
+func TestShuffle(t *testing.T) {
+	q := New()
+	q.Set(entries("a", "b", "c", "d", "e", "f"), 3)
+	q.SetShuffle(true)
+
+	if _, i, _ := q.Current(); i != 0 || current(t, q) != "d" {
+		t.Fatalf("current is %s at %d, want d at 0", current(t, q), i)
+	}
+	got := videoIds(q.Entries())
+	slices.Sort(got)
+	if want := []string{"a", "b", "c", "d", "e", "f"}; !slices.Equal(got, want) {
+		t.Fatalf("shuffled entries are %v, want %v", got, want)
+	}
+}
+
+func TestUnshuffle(t *testing.T) {
+	q := New()
+	q.Set(entries("a", "b", "c", "d"), 1)
+	q.SetShuffle(true)
+	q.Enqueue(entries("e")...)
+	q.Remove(q.Len() - 2)
+	q.SetShuffle(false)
+
+	got := videoIds(q.Entries())
+	if len(got) != 4 || got[len(got)-1] != "e" {
+		t.Fatalf("unshuffled entries are %v, want the original order with e last", got)
+	}
+	want := slices.DeleteFunc([]string{"a", "b", "c", "d", "e"}, func(id string) bool {
+		return !slices.Contains(got, id)
+	})
+	if !slices.Equal(got, want) {
+		t.Fatalf("unshuffled entries are %v, want %v", got, want)
+	}
+	if current(t, q) != "b" {
+		t.Fatalf("current is %s, want b", current(t, q))
+	}
+}
+
+func TestMoveKeepsCurrent(t *testing.T) {
+	q := New()
+	q.Set(entries("a", "b", "c", "d"), 1)
+
+	q.Move(0, 3)
+	if got, want := videoIds(q.Entries()), []string{"b", "c", "d", "a"}; !slices.Equal(got, want) {
+		t.Fatalf("entries are %v, want %v", got, want)
+	}
+	if _, i, _ := q.Current(); i != 0 || current(t, q) != "b" {
+		t.Fatalf("current is %s at %d, want b at 0", current(t, q), i)
+	}
+
+	q.Move(0, 2)
+	if _, i, _ := q.Current(); i != 2 || current(t, q) != "b" {
+		t.Fatalf("current is %s at %d, want b at 2", current(t, q), i)
+	}
+	if next, _ := q.Following(); next.Track.VideoId != "a" {
+		t.Fatalf("following is %s, want a", next.Track.VideoId)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	q := New()
+	q.Set(entries("a", "b", "c", "d"), 0)
+	q.Jump(2)
+	q.Next()
+
+	for _, want := range []string{"c", "a"} {
+		entry, ok := q.Previous()
+		if !ok || entry.Track.VideoId != want {
+			t.Fatalf("previous is %s, want %s", entry.Track.VideoId, want)
+		}
+	}
+	// History is used up, back to the entry before
+	if _, ok := q.Previous(); ok {
+		t.Fatalf("previous from the first entry should fail, current is %s", current(t, q))
+	}
+}
+
+func TestRemoveCurrentCarriesOn(t *testing.T) {
+	q := New()
+	q.Set(entries("a", "b", "c", "d"), 1)
+	q.Remove(1)
+
+	if next, _ := q.Following(); next.Track.VideoId != "c" {
+		t.Fatalf("following is %s, want c", next.Track.VideoId)
+	}
+	if got, want := videoIds(q.Upcoming(5)), []string{"c", "d"}; !slices.Equal(got, want) {
+		t.Fatalf("upcoming is %v, want %v", got, want)
+	}
+
+	// Removing before and playing next shift where it carries on from
+	q.Remove(0)
+	q.PlayNext(entries("x")...)
+	if got, want := videoIds(q.Upcoming(5)), []string{"x", "c", "d"}; !slices.Equal(got, want) {
+		t.Fatalf("upcoming is %v, want %v", got, want)
+	}
+	if entry, _ := q.Finished(); entry.Track.VideoId != "x" {
+		t.Fatalf("finished moved to %s, want x", entry.Track.VideoId)
+	}
+
+	// Removing the last entry finishes the queue unless it repeats
+	q.Jump(q.Len() - 1)
+	q.Remove(q.Len() - 1)
+	if _, ok := q.Following(); ok {
+		t.Fatal("following after removing the last entry should fail")
+	}
+	q.SetRepeat(RepeatAll)
+	if entry, _ := q.Next(); entry.Track.VideoId != "x" {
+		t.Fatalf("next moved to %s, want x", entry.Track.VideoId)
+	}
+}
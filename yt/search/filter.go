@@ -11,6 +11,8 @@ const (
 	CommunityPlaylists
 	FeaturedPlaylists
 	Uploads
+	Podcasts
+	Episodes
 )
 
 func (f Filter) String() string {
@@ -21,6 +23,9 @@ func (f Filter) String() string {
 	case Videos:
 		return "videos"
 
+	case Albums:
+		return "albums"
+
 	case Artists:
 		return "artists"
 
@@ -36,6 +41,12 @@ func (f Filter) String() string {
 	case Uploads:
 		return "uploads"
 
+	case Podcasts:
+		return "podcasts"
+
+	case Episodes:
+		return "episodes"
+
 	}
 	return ""
 }
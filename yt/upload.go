@@ -0,0 +1,169 @@
+package yt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrUploadTimeout = errors.New("upload did not show up in library")
+
+// File types YouTube Music accepts for uploads
+var UploadExtensions = []string{".mp3", ".m4a", ".wma", ".flac", ".ogg"}
+
+type UploadStatus string
+
+const (
+	UploadSucceeded UploadStatus = "STATUS_SUCCEEDED"
+)
+
+// UploadArtist is an artist in the uploads section of the library
+type UploadArtist struct {
+	Artist
+	// Something like "3 songs"
+	Songs string `json:"songs"`
+}
+
+func (a *UploadArtist) UnmarshalJSON(data []byte) error {
+	var songs struct {
+		Songs string `json:"songs"`
+	}
+	err := json.Unmarshal(data, &songs)
+	if err != nil && !isTypeError(err) {
+		return err
+	}
+	a.Songs = songs.Songs
+	if artistErr := json.Unmarshal(data, &a.Artist); artistErr != nil {
+		return artistErr
+	}
+	return err
+}
+
+func IsUploadable(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range UploadExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// UploadSong uploads the file at path to the library. A succeeded status only
+// means YouTube accepted the file, it still has to be processed before it
+// shows up in UploadedSongs(). Use WaitForUpload() to poll for that.
+func (ytm *YTMClient) UploadSong(path string) (UploadStatus, error) {
+	if !IsUploadable(path) {
+		return "", fmt.Errorf("UploadSong(): unsupported file type %s", filepath.Ext(path))
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("UploadSong(): %w", err)
+	}
+
+	result, err := ytm.runPyScript(pyStatus(fmt.Sprintf("ytmusic.upload_song(%s)", pyString(abs))))
+	if err != nil {
+		return "", fmt.Errorf("UploadSong(): %w", err)
+	}
+
+	var status string
+	if err = json.Unmarshal([]byte(result), &status); err != nil {
+		return "", fmt.Errorf("UploadSong(): upload of %s failed with HTTP status %s", abs, strings.TrimSpace(result))
+	}
+	if UploadStatus(status) != UploadSucceeded {
+		return UploadStatus(status), fmt.Errorf("UploadSong(): upload of %s returned %s", abs, status)
+	}
+	return UploadStatus(status), nil
+}
+
+// WaitForUpload polls the uploaded songs until one that isn't in known and
+// that match accepts shows up, and returns it. Every new song it sees is
+// added to known, so one that took longer than its own wait to process
+// isn't mistaken for the next upload.
+func (ytm *YTMClient) WaitForUpload(known map[string]bool, match func(Track) bool, interval time.Duration, timeout time.Duration) (Track, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		songs, err := ytm.UploadedSongs(0)
+		if err != nil {
+			return Track{}, fmt.Errorf("WaitForUpload(): %w", err)
+		}
+
+		var found *Track
+		for i, song := range songs {
+			if known[song.EntityId] {
+				continue
+			}
+			known[song.EntityId] = true
+			if found == nil && match(song) {
+				found = &songs[i]
+			}
+		}
+		if found != nil {
+			return *found, nil
+		}
+
+		if time.Now().After(deadline) {
+			return Track{}, ErrUploadTimeout
+		}
+		log.Printf("WaitForUpload(): nothing new yet, checking again in %s", interval)
+		time.Sleep(interval)
+	}
+}
+
+// UploadedSongs lists uploaded songs. A limit of 0 fetches all of them.
+func (ytm *YTMClient) UploadedSongs(limit int) ([]Track, error) {
+	var songs []Track
+	if err := libraryUploads(ytm, "get_library_upload_songs", limit, &songs); err != nil {
+		return nil, fmt.Errorf("UploadedSongs(): %w", err)
+	}
+	return songs, nil
+}
+
+func (ytm *YTMClient) UploadedAlbums(limit int) ([]Album, error) {
+	var albums []Album
+	if err := libraryUploads(ytm, "get_library_upload_albums", limit, &albums); err != nil {
+		return nil, fmt.Errorf("UploadedAlbums(): %w", err)
+	}
+	return albums, nil
+}
+
+func (ytm *YTMClient) UploadedArtists(limit int) ([]UploadArtist, error) {
+	var artists []UploadArtist
+	if err := libraryUploads(ytm, "get_library_upload_artists", limit, &artists); err != nil {
+		return nil, fmt.Errorf("UploadedArtists(): %w", err)
+	}
+	return artists, nil
+}
+
+// DeleteUpload removes an uploaded song or album. entityId is Track.EntityId
+// for songs or Album.ID for albums.
+func (ytm *YTMClient) DeleteUpload(entityId string) error {
+	result, err := ytm.runPyScript(pyStatus(fmt.Sprintf("ytmusic.delete_upload_entity(%s)", pyString(entityId))))
+	if err != nil {
+		return fmt.Errorf("DeleteUpload(): %w", err)
+	}
+
+	var status string
+	if err = json.Unmarshal([]byte(result), &status); err != nil || UploadStatus(status) != UploadSucceeded {
+		return fmt.Errorf("DeleteUpload(): failed to delete %s: %s", entityId, strings.TrimSpace(result))
+	}
+	return nil
+}
+
+func libraryUploads[T any](ytm *YTMClient, method string, limit int, v *[]T) error {
+	pyLimit := "None"
+	if limit > 0 {
+		pyLimit = fmt.Sprint(limit)
+	}
+	return listInto(ytm, method, fmt.Sprintf("ytmusic.%s(limit=%s)", method, pyLimit), v)
+}
+
+// The upload endpoints return a status string on success and a
+// requests.Response otherwise, which json can't encode.
+func pyStatus(call string) string {
+	return fmt.Sprintf("(lambda r: r if isinstance(r, str) else r.status_code)(%s)", call)
+}
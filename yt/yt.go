@@ -1,6 +1,7 @@
 package yt
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,11 +9,12 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/kkdai/youtube/v2"
-	"github.com/lordxarus/ytmusic_cli/yt/home"
+	"github.com/lordxarus/ytmusic_cli/yt/auth"
 	"github.com/lordxarus/ytmusic_cli/yt/search"
 	"github.com/sanity-io/litter"
 )
@@ -23,13 +25,27 @@ type YTMClient struct {
 	oauthToken string
 	brandId    string
 	cachePath  string
+	// Set by NewWithCredentials, takes priority over oauthToken
+	creds *auth.Store
+
+	formatPolicy FormatPolicy
+	// Tried in order, see SetDownloaders
+	downloaders []Downloader
+	// Downloads in progress, closed when they finish
+	downloadsMu sync.Mutex
+	downloads   map[string]chan struct{}
+
+	warningsMu sync.Mutex
+	warnings   []Warning
 }
 
+// New creates a client from the raw auth string ytmusicapi takes,
+// either a path to a headers file or its JSON contents.
 func New(token string, id string, cachePath string) (*YTMClient, error) {
 	client := &YTMClient{
-		token,
-		id,
-		cachePath,
+		oauthToken: token,
+		brandId:    id,
+		cachePath:  cachePath,
 	}
 	_, err := client.Home()
 	if err != nil {
@@ -38,80 +54,175 @@ func New(token string, id string, cachePath string) (*YTMClient, error) {
 	return client, nil
 }
 
-func (ytm *YTMClient) DownloadVideo(videoId string) error {
-	var fullPath string
-	if ytm.cachePath != "" {
-		fullPath = filepath.Join(ytm.cachePath, videoId+".mp4")
-		_, err := os.Open(fullPath)
-		if err == nil {
-			log.Println("DownloadVideo(): already downloaded", videoId)
-			return ErrAlreadyDownloaded
-		}
+// NewWithCredentials creates a client from credentials saved by the auth
+// subcommand. Expired OAuth tokens are refreshed before each request.
+func NewWithCredentials(creds *auth.Store, id string, cachePath string) (*YTMClient, error) {
+	client := &YTMClient{
+		brandId:   id,
+		cachePath: cachePath,
+		creds:     creds,
 	}
-
-	client := youtube.Client{}
-
-	video, err := client.GetVideo(videoId)
+	_, err := client.Home()
 	if err != nil {
-		return fmt.Errorf("DownloadVideo(): failed to get video: %w", err)
+		return nil, fmt.Errorf("NewWithCredentials() sanity check failed: %w", err)
 	}
+	return client, nil
+}
 
-	formats := video.Formats.WithAudioChannels() // only get videos with audio
+// For now I need a string for AddToHistory()
 
-	stream, _, err := client.GetStream(video, &formats[0])
+// Home returns the shelves of the home page. Items that fail to decode are
+// skipped, see Warnings().
+func (ytm *YTMClient) Home() ([]Shelf, error) {
+	result, err := ytm.runPyScript("ytmusic.get_home()")
 	if err != nil {
-		return fmt.Errorf("DownloadVideo(): failed to get stream: %w", err)
+		return nil, fmt.Errorf("Home() failed getting home results: %w", err)
 	}
-	defer stream.Close()
 
-	file, err := os.Create(fullPath)
+	d := newDecoder("get_home")
+	defer ytm.record(d)
+	results, err := decodeShelves(d, []byte(result))
 	if err != nil {
-		return fmt.Errorf("DownloadVideo(): failed to create file, %s for %s: %w", fullPath, videoId, err)
+		return nil, fmt.Errorf("Home() %w", err)
 	}
-	defer file.Close()
+	return results, nil
+}
 
-	_, err = io.Copy(file, stream)
-	if err != nil {
-		return fmt.Errorf("DownloadVideo(): failed to copy stream to file, %s: %w", fullPath, err)
+// Search for tracks. filter should be search.Songs or search.Videos,
+// use SearchAlbums() and friends for everything else.
+func (ytm *YTMClient) Search(query string, filter search.Filter) ([]Track, error) {
+	var songResults []Track
+	if err := searchInto(ytm, query, filter, &songResults); err != nil {
+		return nil, fmt.Errorf("Search(): %w", err)
 	}
+	return songResults, nil
+}
 
-	return nil
+func (ytm *YTMClient) SearchAlbums(query string) ([]Album, error) {
+	var albums []Album
+	if err := searchInto(ytm, query, search.Albums, &albums); err != nil {
+		return nil, fmt.Errorf("SearchAlbums(): %w", err)
+	}
+	return albums, nil
 }
 
-// For now I need a string for AddToHistory()
+func (ytm *YTMClient) SearchArtists(query string) ([]Artist, error) {
+	var artists []Artist
+	if err := searchInto(ytm, query, search.Artists, &artists); err != nil {
+		return nil, fmt.Errorf("SearchArtists(): %w", err)
+	}
+	return artists, nil
+}
 
-func (ytm *YTMClient) Home() (home.Results, error) {
-	results := make(home.Results, 3)
-	var returnErr error
+func (ytm *YTMClient) SearchPlaylists(query string) ([]Playlist, error) {
+	var playlists []Playlist
+	if err := searchInto(ytm, query, search.Playlists, &playlists); err != nil {
+		return nil, fmt.Errorf("SearchPlaylists(): %w", err)
+	}
+	return playlists, nil
+}
 
-	result, err := ytm.runPyScript("ytmusic.get_home()")
+// GetAlbum fetches an album and its tracks. id is the album's browse ID.
+func (ytm *YTMClient) GetAlbum(id string) (Album, []Track, error) {
+	var album Album
+
+	result, err := ytm.runPyScript(fmt.Sprintf("ytmusic.get_album(%s)", pyString(id)))
 	if err != nil {
-		return nil, fmt.Errorf("Home() failed getting home results: %w", err)
+		return album, nil, fmt.Errorf("GetAlbum(): %w", err)
 	}
 
-	err = json.Unmarshal([]byte(result), &results) // https://betterstack.com/community/guides/scaling-go/json-in-go/
+	var raw struct {
+		Tracks json.RawMessage `json:"tracks"`
+	}
+	d := newDecoder("get_album")
+	defer ytm.record(d)
+	if err = json.Unmarshal([]byte(result), &album); err != nil {
+		if !isTypeError(err) {
+			return album, nil, fmt.Errorf("GetAlbum(): unable to unmarshal JSON: %w", err)
+		}
+		d.warn("$", err, false)
+	}
+	if err = json.Unmarshal([]byte(result), &raw); err != nil {
+		return album, nil, fmt.Errorf("GetAlbum(): unable to unmarshal JSON: %w", err)
+	}
+	tracks, err := decodeList[Track](d, raw.Tracks, "$.tracks")
 	if err != nil {
-		returnErr = fmt.Errorf("Home() unable to marshal JSON", err)
+		return album, nil, fmt.Errorf("GetAlbum(): %w", err)
 	}
 
-	return results, returnErr
+	album.ID = id
+	// The tracks only have the album's name and no art of their own
+	for i := range tracks {
+		tracks[i].Album = album
+		if tracks[i].Year == 0 {
+			tracks[i].Year = album.Year
+		}
+		if len(tracks[i].Thumbnails) == 0 {
+			tracks[i].Thumbnails = album.Thumbnails
+		}
+	}
+	return album, tracks, nil
 }
 
-func (ytm *YTMClient) Search(query string, filter search.Filter) ([]Song, error) {
-	var returnErr error
+// GetPlaylist fetches a playlist and all of its tracks
+func (ytm *YTMClient) GetPlaylist(id string) (Playlist, []Track, error) {
+	var playlist Playlist
+
+	result, err := ytm.runPyScript(fmt.Sprintf("ytmusic.get_playlist(%s, limit=None)", pyString(id)))
+	if err != nil {
+		return playlist, nil, fmt.Errorf("GetPlaylist(): %w", err)
+	}
 
-	result, err := ytm.runPyScript(fmt.Sprintf("ytmusic.search('%s', filter='%s')", query, filter))
+	var raw struct {
+		Tracks json.RawMessage `json:"tracks"`
+	}
+	d := newDecoder("get_playlist")
+	defer ytm.record(d)
+	if err = json.Unmarshal([]byte(result), &playlist); err != nil {
+		if !isTypeError(err) {
+			return playlist, nil, fmt.Errorf("GetPlaylist(): unable to unmarshal JSON: %w", err)
+		}
+		d.warn("$", err, false)
+	}
+	if err = json.Unmarshal([]byte(result), &raw); err != nil {
+		return playlist, nil, fmt.Errorf("GetPlaylist(): unable to unmarshal JSON: %w", err)
+	}
+	tracks, err := decodeList[Track](d, raw.Tracks, "$.tracks")
 	if err != nil {
-		return nil, err
+		return playlist, nil, fmt.Errorf("GetPlaylist(): %w", err)
+	}
+	return playlist, tracks, nil
+}
+
+// LibrarySongs lists the songs saved to the library. limit <= 0 gets all of them.
+func (ytm *YTMClient) LibrarySongs(limit int) ([]Track, error) {
+	pyLimit := "None"
+	if limit > 0 {
+		pyLimit = fmt.Sprint(limit)
 	}
+	var songs []Track
+	if err := listInto(ytm, "get_library_songs", fmt.Sprintf("ytmusic.get_library_songs(limit=%s)", pyLimit), &songs); err != nil {
+		return nil, fmt.Errorf("LibrarySongs(): %w", err)
+	}
+	return songs, nil
+}
 
-	songResults := make([]Song, 50)
+func searchInto[T any](ytm *YTMClient, query string, filter search.Filter, v *[]T) error {
+	return listInto(ytm, "search", fmt.Sprintf("ytmusic.search(%s, filter='%s')", pyString(query), filter), v)
+}
 
-	err = json.Unmarshal([]byte(result), &songResults) // https://betterstack.com/community/guides/scaling-go/json-in-go/
+// listInto runs a script that returns a list and decodes it item by item,
+// recording warnings for anything that doesn't fit T
+func listInto[T any](ytm *YTMClient, endpoint string, script string, v *[]T) error {
+	result, err := ytm.runPyScript(script)
 	if err != nil {
-		returnErr = fmt.Errorf("Query(): unable to marshal JSON: %w", err)
+		return err
 	}
-	return songResults, returnErr
+
+	d := newDecoder(endpoint)
+	defer ytm.record(d)
+	*v, err = decodeList[T](d, []byte(result), "$")
+	return err
 }
 
 func (ytm *YTMClient) GetSong(videoId string) (string, error) {
@@ -129,23 +240,67 @@ func (ytm *YTMClient) GetSong(videoId string) (string, error) {
 	return result, returnErr
 }
 
+// AddToHistory reports videoId as played. The song lookup and the report
+// happen in the same python process so this only costs one spawn.
+// Callers in the UI should go through a history.Outbox instead of calling this
+// directly, it blocks and doesn't retry.
 func (ytm *YTMClient) AddToHistory(videoId string) error {
-	song, err := ytm.GetSong(videoId)
-	if err != nil {
-		return fmt.Errorf("AddToHistory(): %w", err)
-	}
-	res, err := ytm.runPyScript(fmt.Sprintf("ytmusic.add_history_item(json.load('%s'))", song))
+	// add_history_item returns a requests.Response which json can't encode
+	res, err := ytm.runPyScript(fmt.Sprintf("ytmusic.add_history_item(ytmusic.get_song(%s)).status_code", pyString(videoId)))
 	if err != nil {
 		return fmt.Errorf("AddToHistory(): failed to add to history: %w", err)
 	}
 	log.Printf("AddToHistory(): %s", litter.Sdump(res))
+	status, err := strconv.Atoi(strings.TrimSpace(res))
+	if err != nil {
+		return fmt.Errorf("AddToHistory(): unexpected response %q: %w", res, err)
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("AddToHistory(): server returned status %d", status)
+	}
 	return nil
 }
 
+// pyConstructor is the python expression that builds the YTMusic object
+func (ytm *YTMClient) pyConstructor() (string, error) {
+	if ytm.creds == nil {
+		if ytm.oauthToken == "" {
+			return "", errors.New("no OAuth token provided. can't run python script")
+		}
+		return fmt.Sprintf("YTMusic(%s, %s)", pyString(ytm.oauthToken), pyOptString(ytm.brandId)), nil
+	}
+
+	creds, err := ytm.creds.Credentials(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	switch creds.Kind {
+	case auth.KindOAuth:
+		if creds.Token == nil {
+			return "", errors.New("credentials have no OAuth token, run auth again")
+		}
+		token, err := json.Marshal(creds.Token)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("YTMusic(%s, %s, oauth_credentials=OAuthCredentials(client_id=%s, client_secret=%s))",
+			pyString(string(token)), pyOptString(ytm.brandId), pyString(creds.ClientId), pyString(creds.ClientSecret)), nil
+	case auth.KindBrowser:
+		headers, err := json.Marshal(creds.Headers)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("YTMusic(%s, %s)", pyString(string(headers)), pyOptString(ytm.brandId)), nil
+	}
+	return "", fmt.Errorf("unknown credential kind %q", creds.Kind)
+}
+
 func (ytm *YTMClient) runPyScript(script string) (string, error) {
 	var returnErr error
-	if ytm.oauthToken == "" {
-		return "", errors.New("no OAuth token provided. can't run python script")
+	constructor, err := ytm.pyConstructor()
+	if err != nil {
+		return "", fmt.Errorf("runPyScript(): %w", err)
 	}
 	// Search
 
@@ -161,10 +316,10 @@ func (ytm *YTMClient) runPyScript(script string) (string, error) {
 	// ep.AddPythonPath(fs.GetExtractedPath())
 
 	cmd := exec.Command("python3", "-c", fmt.Sprintf(`
-from ytmusicapi import YTMusic
+from ytmusicapi import YTMusic, OAuthCredentials
 import sys
 	
-ytmusic = YTMusic('%s', '%s')
+ytmusic = %s
 	
 res = %s
 	
@@ -176,7 +331,7 @@ print(json.dumps(
  	sort_keys=True,
  	indent=4,
  	separators=(',', ': ')
- ))`, ytm.oauthToken, ytm.brandId, script))
+ ))`, constructor, script))
 
 	stdout, err := cmd.Output()
 	if err != nil {
@@ -192,6 +347,21 @@ print(json.dumps(
 	return string(stdout), returnErr
 }
 
+// pyOptString is pyString but an empty s becomes None
+func pyOptString(s string) string {
+	if s == "" {
+		return "None"
+	}
+	return pyString(s)
+}
+
+// pyString quotes s so it can be dropped into a python script. JSON string
+// literals are valid python string literals.
+func pyString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
 // Example usage for playlists: downloading and checking information.
 func examplePlaylist() {
 	playlistID := "PLQZgI7en5XEgM0L1_ZcKmEzxW1sCOVZwP"